@@ -0,0 +1,237 @@
+package apiserver
+
+import (
+	"context"
+	"fmt"
+	"github.com/xzeus/cqrs"
+	"github.com/xzeus/cqrs/ioc"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	ETag            = "ETag"
+	IfNoneMatch     = "If-None-Match"
+	LastModified    = "Last-Modified"
+	IfModifiedSince = "If-Modified-Since"
+)
+
+const (
+	version_prefix = "apiserver:version:"
+	view_prefix    = "apiserver:view:"
+)
+
+// Validator identifies a version of a view's content
+type Validator struct {
+	ETag         string
+	LastModified time.Time
+}
+
+// VersionValidator derives the validator from an aggregate's version
+func VersionValidator(domain int32, id int64, version int32, timestamp int64) Validator {
+	return Validator{
+		ETag:         fmt.Sprintf(`"%X-%X-%d"`, uint32(domain), uint64(id), version),
+		LastModified: time.Unix(0, timestamp),
+	}
+}
+
+// CheckpointValidator derives the validator from a projection's checkpoint
+func CheckpointValidator(projection string, checkpoint int64, timestamp int64) Validator {
+	return Validator{
+		ETag:         fmt.Sprintf(`"%s-%d"`, projection, checkpoint),
+		LastModified: time.Unix(0, timestamp),
+	}
+}
+
+// Matches reports whether the request's preconditions already hold this
+// version, If-None-Match takes precedence over If-Modified-Since
+func (v Validator) Matches(r *http.Request) bool {
+	if tags := r.Header.Get(IfNoneMatch); tags != "" {
+		for _, tag := range strings.Split(tags, ",") {
+			tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+			if tag == "*" || (v.ETag != "" && tag == v.ETag) {
+				return true
+			}
+		}
+		return false
+	}
+	if since := r.Header.Get(IfModifiedSince); since != "" && !v.LastModified.IsZero() {
+		t, err := http.ParseTime(since)
+		return err == nil && !v.LastModified.Truncate(time.Second).After(t)
+	}
+	return false
+}
+
+func (v Validator) write(resp Response) {
+	if v.ETag != "" {
+		resp.Recorder().Header().Set(ETag, v.ETag)
+	}
+	if !v.LastModified.IsZero() {
+		resp.Recorder().Header().Set(LastModified, v.LastModified.UTC().Format(http.TimeFormat))
+	}
+}
+
+// ValidatorFunc cheaply finds the current validator of the view without
+// building it
+type ValidatorFunc func(Request) (Validator, error)
+
+type versionRecord struct {
+	Version   int32 `json:"version"`
+	Timestamp int64 `json:"timestamp"`
+}
+
+func versionKey(domain int32, id int64) string {
+	return fmt.Sprintf("%s%X:%X", version_prefix, uint32(domain), uint64(id))
+}
+
+// RecordVersion keeps the event's aggregate version in the CacheStore for
+// AggregateVersion, it must see every event of the aggregate once any is
+// recorded or views keep the version it last saw, see VersionRecorder
+func RecordVersion(deps ioc.Dependencies, m cqrs.Message) error {
+	return deps.CacheStore().Set(versionKey(m.GetDomainId(), m.GetId()), &versionRecord{
+		Version:   m.GetVersion(),
+		Timestamp: m.GetTimestamp(),
+	})
+}
+
+// VersionRecorder records the version of each of the domains' events as
+// they're published, add it to the server's Runners so that AggregateVersion
+// reads versions from the CacheStore rather than the EventStore
+func VersionRecorder(deps ioc.Dependencies, subscriber ioc.Subscriber, domains ...cqrs.Domain) *SubscriptionRunner {
+	ids := make(map[int32]bool)
+	for _, d := range domains {
+		ids[d.Id()] = true
+	}
+	return &SubscriptionRunner{
+		Name:       "versions",
+		Subscriber: subscriber,
+		Filter:     func(m cqrs.Message) bool { return ids[m.GetDomainId()] },
+		Handle: func(_ context.Context, m cqrs.Message) {
+			if err := RecordVersion(deps, m); err != nil {
+				deps.Logger().With(ioc.Fields{ioc.FieldError: err}).Warnf("unable to record version")
+			}
+		},
+	}
+}
+
+// AggregateVersion derives the validator from the version recorded by
+// RecordVersion for the aggregate of the named hex id parameter, reading the
+// aggregate's last stored event while none is recorded
+func AggregateVersion(d cqrs.Domain, param string) ValidatorFunc {
+	return func(req Request) (Validator, error) {
+		aggregate_id, err := req.HexParam(param)
+		if err != nil {
+			return Validator{}, ErrInvalidId
+		}
+		deps := req.Deps()
+		record := &versionRecord{}
+		if err := deps.CacheStore().Get(versionKey(d.Id(), aggregate_id), record); err == nil {
+			return VersionValidator(d.Id(), aggregate_id, record.Version, record.Timestamp), nil
+		}
+		events, err := deps.EventStore().GetAggregateEvents(d.Id(), aggregate_id, 0)
+		if err != nil {
+			return Validator{}, err
+		}
+		if len(events) == 0 {
+			return Validator{}, cqrs.ErrNoSuchAggregate
+		}
+		last := events[len(events)-1]
+		return VersionValidator(d.Id(), aggregate_id, last.GetVersion(), last.GetTimestamp()), nil
+	}
+}
+
+func conditional(v ValidatorFunc, h func(Request, Response, Validator)) ApiFunc {
+	return func(req Request, resp Response) {
+		if m := req.Request().Method; m != "GET" && m != "HEAD" {
+			h(req, resp, Validator{})
+			return
+		}
+		validator, err := v(req)
+		if err != nil { // Let the view respond, i.e. with a 404
+			h(req, resp, Validator{})
+			return
+		}
+		if validator.Matches(req.Request()) {
+			validator.write(resp)
+			resp.Empty(http.StatusNotModified)
+			return
+		}
+		h(req, resp, validator)
+		if resp.Recorder().Code == 200 {
+			validator.write(resp)
+		}
+	}
+}
+
+// Conditional responds 304 without building the view when the request's
+// If-None-Match or If-Modified-Since hold the current version
+func Conditional(v ValidatorFunc, h ApiFunc) ApiFunc {
+	return conditional(v, func(req Request, resp Response, _ Validator) {
+		h(req, resp)
+	})
+}
+
+type cachedView struct {
+	ETag        string `json:"etag"`
+	ContentType string `json:"content_type"`
+	Body        []byte `json:"body"`
+}
+
+// SharedViewKey caches a view by the request uri alone, only for views which
+// are the same for every caller
+func SharedViewKey(req Request) string {
+	return req.Request().URL.RequestURI()
+}
+
+// SubjectViewKey caches a view by the request uri for each subject of the
+// verified token, callers without one share an anonymous entry
+func SubjectViewKey(token_key string) func(Request) string {
+	return func(req Request) string {
+		subject := ""
+		if claims, err := req.GetClaims(token_key); err == nil {
+			subject = claims.Subject
+		}
+		return "sub:" + subject + "|" + req.Request().URL.RequestURI()
+	}
+}
+
+// CachedView is Conditional and also keeps the view's response in the
+// CacheStore by key until the version changes or it's removed by
+// InvalidateView, the key must include whatever the view varies by such as
+// the caller so there's no default, streamed responses aren't cached
+func CachedView(key func(Request) string, v ValidatorFunc, h ApiFunc) ApiFunc {
+	if key == nil {
+		panic("CachedView requires a key such as SharedViewKey or SubjectViewKey")
+	}
+	return conditional(v, func(req Request, resp Response, validator Validator) {
+		if validator.ETag == "" {
+			h(req, resp)
+			return
+		}
+		cache := req.Deps().CacheStore()
+		cache_key := view_prefix + key(req)
+		cached := &cachedView{}
+		if err := cache.Get(cache_key, cached); err == nil && cached.ETag == validator.ETag {
+			resp.Binary(cached.Body, cached.ContentType, 200)
+			return
+		}
+		h(req, resp)
+		if resp.Flushed() || resp.Recorder().Code != 200 { // Streams never reach the recorder
+			return
+		}
+		err := cache.Set(cache_key, &cachedView{
+			ETag:        validator.ETag,
+			ContentType: resp.Recorder().Header().Get("Content-Type"),
+			Body:        append([]byte{}, resp.Recorder().Body.Bytes()...),
+		})
+		if err != nil {
+			req.Deps().Logger().With(ioc.Fields{ioc.FieldError: err}).Warnf("unable to cache view")
+		}
+	})
+}
+
+// InvalidateView drops the cached response for the key
+func InvalidateView(deps ioc.Dependencies, key string) error {
+	return deps.CacheStore().Delete(view_prefix + key)
+}
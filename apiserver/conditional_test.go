@@ -0,0 +1,131 @@
+package apiserver_test
+
+import (
+	"context"
+	"github.com/xzeus/cqrs"
+	. "github.com/xzeus/cqrs/apiserver"
+	"github.com/xzeus/cqrs/ioc"
+	. "github.com/xzeus/cqrs/testing"
+	"github.com/xzeus/cqrs/testing/testdeps"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func Test_Should_skip_unchanged_views_and_cache_by_version(t *testing.T) {
+	deps := testdeps.NewDependencies()
+	store := deps.EventStore()
+	_, err := store.AppendEvent(0x20, 1, []cqrs.AggregateHeader{}, &Opened{Title: "first"})
+	Ok(t, err)
+	built := 0
+	view := ByIdFromPath("id", func(deps ioc.Dependencies, id int64) (interface{}, error) {
+		built++
+		return map[string]int{"built": built}, nil
+	})
+	s, err := NewServer(testProvider(deps))
+	Ok(t, err)
+	s.Define(View("tickets/"+URL_HEX_ID, CachedView(SharedViewKey, AggregateVersion(ticketDomain, "id"), view)))
+	test_server := httptest.NewServer(s.BuildRouter())
+	defer test_server.Close()
+	get := func(etag string) *http.Response {
+		r, _ := http.NewRequest("GET", test_server.URL+"/tickets/20", nil)
+		if etag != "" {
+			r.Header.Set("If-None-Match", etag)
+		}
+		res, err := http.DefaultClient.Do(r)
+		Ok(t, err)
+		res.Body.Close()
+		return res
+	}
+
+	res := get("")
+	Equals(t, 200, res.StatusCode, "should build the view")
+	etag := res.Header.Get("ETag")
+	Assert(t, etag != "", "should tag the view")
+	Assert(t, res.Header.Get("Last-Modified") != "", "should date the view")
+	Equals(t, 304, get(etag).StatusCode, "should not rebuild an unchanged view")
+	Equals(t, 200, get("").StatusCode, "should serve the cached view")
+	Equals(t, 1, built, "should only have built the view once")
+
+	_, err = store.AppendEvent(0x20, 2, []cqrs.AggregateHeader{}, &Opened{Title: "second"})
+	Ok(t, err)
+	res = get(etag)
+	Equals(t, 200, res.StatusCode, "should rebuild from the stored version while none is recorded")
+	NotEquals(t, etag, res.Header.Get("ETag"), "should tag the new version")
+	Equals(t, 2, built, "should have rebuilt the view")
+	etag = res.Header.Get("ETag")
+
+	e, err := store.AppendEvent(0x20, 3, []cqrs.AggregateHeader{}, &Opened{Title: "third"})
+	Ok(t, err)
+	Ok(t, RecordVersion(deps, e))
+	res = get(etag)
+	Equals(t, 200, res.StatusCode, "should rebuild once the recorded version changes")
+	NotEquals(t, etag, res.Header.Get("ETag"), "should tag the recorded version")
+	Equals(t, 3, built, "should have rebuilt the view")
+}
+
+func Test_Should_record_published_versions(t *testing.T) {
+	deps := testdeps.NewDependencies()
+	hub := ioc.NewPublisherHub(nil)
+	runner := VersionRecorder(deps, hub, ticketDomain)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- runner.Run(ctx) }()
+	s, err := NewServer(testProvider(deps))
+	Ok(t, err)
+	s.Define(View("tickets/"+URL_HEX_ID, Conditional(AggregateVersion(ticketDomain, "id"), func(req Request, resp Response) {
+		resp.Text("ticket", 200)
+	})))
+	test_server := httptest.NewServer(s.BuildRouter())
+	defer test_server.Close()
+	e, err := deps.EventStore().AppendEvent(0x22, 1, []cqrs.AggregateHeader{}, &Opened{Title: "first"})
+	Ok(t, err)
+	_, err = deps.EventStore().AppendEvent(0x22, 2, []cqrs.AggregateHeader{}, &Opened{Title: "unpublished"})
+	Ok(t, err)
+	recorded := VersionValidator(ticketDomain.Id(), 0x22, 1, e.GetTimestamp()).ETag
+	etag := ""
+	for i := 0; i < 100 && etag != recorded; i++ { // Published until subscribed
+		hub.Publish(e)
+		time.Sleep(time.Millisecond)
+		res, err := http.Get(test_server.URL + "/tickets/22")
+		Ok(t, err)
+		res.Body.Close()
+		etag = res.Header.Get("ETag")
+	}
+	Equals(t, recorded, etag, "should tag the view with the published version")
+	cancel()
+	Ok(t, <-done)
+}
+
+func Test_Should_not_cache_streamed_views(t *testing.T) {
+	deps := testdeps.NewDependencies()
+	_, err := deps.EventStore().AppendEvent(0x21, 1, []cqrs.AggregateHeader{}, &Opened{Title: "first"})
+	Ok(t, err)
+	s, err := NewServer(testProvider(deps))
+	Ok(t, err)
+	stream := func(req Request, resp Response) {
+		w, err := resp.NDJson(200)
+		Ok(t, err)
+		w.Write("streamed")
+	}
+	s.Define(View("tickets/"+URL_HEX_ID, CachedView(SubjectViewKey("session"), AggregateVersion(ticketDomain, "id"), stream)))
+	test_server := httptest.NewServer(s.BuildRouter())
+	defer test_server.Close()
+	for i := 0; i < 2; i++ {
+		res, err := http.Get(test_server.URL + "/tickets/21")
+		Ok(t, err)
+		body, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		Ok(t, err)
+		Equals(t, "\"streamed\"\n", string(body), "should stream rather than serve an empty cached view")
+	}
+}
+
+func Test_Should_require_a_cached_view_key(t *testing.T) {
+	defer func() {
+		Assert(t, recover() != nil, "should have panicked")
+	}()
+	CachedView(nil, nil, nil)
+}
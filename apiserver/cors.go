@@ -0,0 +1,171 @@
+package apiserver
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CORSConfig describes which cross origin requests are allowed, routes
+// needing different methods or headers wrap their handler in their own
+// config
+type CORSConfig struct {
+	// AllowedOrigins are exact origins such as https://example.com, a
+	// wildcard subdomain such as https://*.example.com or * for any origin,
+	// empty allows only the server's own origin
+	AllowedOrigins []string
+	// AllowedMethods defaults to CORS_DefaultMethods
+	AllowedMethods []string
+	// AllowedHeaders defaults to CORS_DefaultHeaders
+	AllowedHeaders []string
+	// ExposedHeaders are readable by the client beyond the simple headers
+	ExposedHeaders []string
+	// AllowCredentials lets the client send cookies, the origin is echoed
+	// rather than * as browsers require so it can't be combined with a *
+	// origin
+	AllowCredentials bool
+	// MaxAge is how long a preflight may be cached, zero leaves it to the
+	// browser
+	MaxAge time.Duration
+}
+
+func (c CORSConfig) methods() []string {
+	if len(c.AllowedMethods) == 0 {
+		return splitHeader(CORS_DefaultMethods)
+	}
+	return c.AllowedMethods
+}
+
+func (c CORSConfig) headers() []string {
+	if len(c.AllowedHeaders) == 0 {
+		return splitHeader(CORS_DefaultHeaders)
+	}
+	return c.AllowedHeaders
+}
+
+// AllowsOrigin reports whether the origin matches the allowlist
+func (c CORSConfig) AllowsOrigin(r *http.Request, origin string) bool {
+	if len(c.AllowedOrigins) == 0 {
+		scheme := "http://"
+		if r.TLS != nil {
+			scheme = "https://"
+		}
+		return strings.EqualFold(origin, scheme+r.Host)
+	}
+	for _, allowed := range c.AllowedOrigins {
+		if matchOrigin(allowed, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchOrigin(pattern string, origin string) bool {
+	if pattern == "*" || strings.EqualFold(pattern, origin) {
+		return true
+	}
+	i := strings.Index(pattern, "*.")
+	if i < 0 {
+		return false
+	}
+	prefix, suffix := strings.ToLower(pattern[:i]), strings.ToLower(pattern[i+1:])
+	origin = strings.ToLower(origin)
+	return len(origin) > len(prefix)+len(suffix) &&
+		strings.HasPrefix(origin, prefix) &&
+		strings.HasSuffix(origin, suffix) &&
+		!strings.Contains(origin[len(prefix):len(origin)-len(suffix)], "/")
+}
+
+func splitHeader(value string) []string {
+	r := make([]string, 0)
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			r = append(r, v)
+		}
+	}
+	return r
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// NewCORS answers preflight requests without invoking the handler and adds
+// the allow headers to responses for allowed origins, requests from other
+// origins are handled without them so the browser withholds the response,
+// it panics if credentials are allowed from any origin
+func NewCORS(config CORSConfig) MiddlewareFunc {
+	methods := config.methods()
+	headers := config.headers()
+	any_origin := containsFold(config.AllowedOrigins, "*")
+	if any_origin && config.AllowCredentials {
+		panic("CORS can't allow credentials from any origin [ * ], list the origins instead")
+	}
+	return func(h ApiFunc) ApiFunc {
+		return func(req Request, resp Response) {
+			r := req.Request()
+			origin := r.Header.Get(CORS_Origin)
+			allowed := origin != "" && config.AllowsOrigin(r, origin)
+			if r.Method == OPTIONS && origin == "" { // Not a preflight but still not for the handler
+				resp.Empty(204)
+				return
+			} else if r.Method == OPTIONS { // Preflight never reaches the handler
+				request_method := r.Header.Get(CORS_AccessControlRequestMethod)
+				if !allowed || (request_method != "" && !containsFold(methods, request_method)) {
+					resp.Error("cross origin request not allowed", 40310, 403, nil)
+					return
+				}
+				for _, header := range splitHeader(r.Header.Get(CORS_AccessControlRequestHeaders)) {
+					if !containsFold(headers, header) {
+						resp.Error("cross origin header [ "+header+" ] not allowed", 40310, 403, nil)
+						return
+					}
+				}
+				resp.Empty(204)
+			}
+			set := func(header http.Header) {
+				if !any_origin {
+					header.Add("Vary", CORS_Origin)
+				}
+				if !allowed {
+					return
+				}
+				if any_origin {
+					header.Set(CORS_AccessControlAllowOrigin, "*")
+				} else {
+					header.Set(CORS_AccessControlAllowOrigin, origin)
+				}
+				if config.AllowCredentials {
+					header.Set(CORS_AccessControlAllowCredentials, "true")
+				}
+				if r.Method == OPTIONS {
+					header.Set(CORS_AccessControlAllowMethods, strings.Join(methods, ", "))
+					header.Set(CORS_AccessControlAllowHeaders, strings.Join(headers, ", "))
+					if config.MaxAge > 0 {
+						header.Set(CORS_AccessControlMaxAge, strconv.Itoa(int(config.MaxAge/time.Second)))
+					}
+				} else if len(config.ExposedHeaders) > 0 {
+					header.Set(CORS_AccessControlExposeHeaders, strings.Join(config.ExposedHeaders, ", "))
+				}
+			}
+			if r.Method == OPTIONS {
+				set(resp.Recorder().Header())
+				return
+			}
+			resp.OnHeaders(set) // Sent with the response even if reset or streamed
+			h(req, resp)
+		}
+	}
+}
+
+// CORS allows requests from the server's own origin with the default
+// methods and headers
+func CORS(h ApiFunc) ApiFunc {
+	return NewCORS(CORSConfig{})(h)
+}
@@ -0,0 +1,96 @@
+package apiserver_test
+
+import (
+	. "github.com/xzeus/cqrs/apiserver"
+	. "github.com/xzeus/cqrs/testing"
+	"github.com/xzeus/cqrs/testing/testdeps"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func corsRequest(t *testing.T, method string, origin string, set func(*http.Request)) (*http.Response, bool) {
+	deps := testdeps.NewDependencies()
+	s, err := NewServer(testProvider(deps))
+	Ok(t, err)
+	handled := false
+	cors := NewCORS(CORSConfig{
+		AllowedOrigins:   []string{"https://app.example.com", "https://*.example.org"},
+		AllowedMethods:   []string{"GET", "PUT"},
+		AllowCredentials: true,
+		MaxAge:           10 * time.Minute,
+	})
+	h := func(req Request, resp Response) {
+		handled = true
+		resp.Empty(204)
+	}
+	s.Define(Sub("api", func(r RouteNode) {
+		r.AppendHandler(NewHandler("thing", []string{"GET", "PUT", "OPTIONS"}, cors(h)))
+	}))
+	test_server := httptest.NewServer(s.BuildRouter())
+	defer test_server.Close()
+	r, _ := http.NewRequest(method, test_server.URL+"/api/thing", nil)
+	r.Header.Set("Origin", origin)
+	set(r)
+	res, err := http.DefaultClient.Do(r)
+	Ok(t, err)
+	res.Body.Close()
+	return res, handled
+}
+
+func Test_Should_answer_preflight_for_allowed_origin_without_handler(t *testing.T) {
+	res, handled := corsRequest(t, "OPTIONS", "https://eu.example.org", func(r *http.Request) {
+		r.Header.Set("Access-Control-Request-Method", "PUT")
+		r.Header.Set("Access-Control-Request-Headers", "content-type, authorization")
+	})
+	Equals(t, 204, res.StatusCode, "should allow the preflight")
+	Assert(t, !handled, "should not have invoked the handler")
+	Equals(t, "https://eu.example.org", res.Header.Get("Access-Control-Allow-Origin"), "should echo the origin")
+	Equals(t, "true", res.Header.Get("Access-Control-Allow-Credentials"), "should allow credentials")
+	Equals(t, "600", res.Header.Get("Access-Control-Max-Age"), "should set the max age")
+	Equals(t, "GET, PUT", res.Header.Get("Access-Control-Allow-Methods"), "should list the route's methods")
+}
+
+func Test_Should_not_allow_unlisted_origins_or_methods(t *testing.T) {
+	res, handled := corsRequest(t, "GET", "https://evil.example.com", func(r *http.Request) {})
+	Equals(t, 204, res.StatusCode, "should still handle the request")
+	Assert(t, handled, "should have invoked the handler")
+	Equals(t, "", res.Header.Get("Access-Control-Allow-Origin"), "should not allow the origin")
+	res, _ = corsRequest(t, "OPTIONS", "https://app.example.com", func(r *http.Request) {
+		r.Header.Set("Access-Control-Request-Method", "DELETE")
+	})
+	Equals(t, 403, res.StatusCode, "should reject the method")
+	res, _ = corsRequest(t, "OPTIONS", "https://a.b.example.org.evil.com", func(r *http.Request) {
+		r.Header.Set("Access-Control-Request-Method", "GET")
+	})
+	Equals(t, 403, res.StatusCode, "should not match the wildcard")
+}
+
+func Test_Should_send_allow_headers_with_a_stream(t *testing.T) {
+	s, err := NewServer(testProvider(testdeps.NewDependencies()))
+	Ok(t, err)
+	cors := NewCORS(CORSConfig{AllowedOrigins: []string{"https://app.example.com"}, ExposedHeaders: []string{"X-Total"}})
+	s.Define(View("stream", cors(func(req Request, resp Response) {
+		w, err := resp.NDJson(200)
+		Ok(t, err)
+		w.Write(1)
+	})))
+	test_server := httptest.NewServer(s.BuildRouter())
+	defer test_server.Close()
+	r, _ := http.NewRequest("GET", test_server.URL+"/stream", nil)
+	r.Header.Set("Origin", "https://app.example.com")
+	res, err := http.DefaultClient.Do(r)
+	Ok(t, err)
+	res.Body.Close()
+	Equals(t, "https://app.example.com", res.Header.Get("Access-Control-Allow-Origin"), "should allow the origin")
+	Equals(t, "Origin", res.Header.Get("Vary"), "should vary by origin")
+	Equals(t, "X-Total", res.Header.Get("Access-Control-Expose-Headers"), "should expose the headers")
+}
+
+func Test_Should_panic_when_credentials_are_allowed_from_any_origin(t *testing.T) {
+	defer func() {
+		Assert(t, recover() != nil, "should have panicked")
+	}()
+	NewCORS(CORSConfig{AllowedOrigins: []string{"*"}, AllowCredentials: true})
+}
@@ -3,8 +3,9 @@ package apiserver
 import (
 	"fmt"
 	"github.com/xzeus/cqrs"
+	"github.com/xzeus/cqrs/domains"
+	"github.com/xzeus/cqrs/exception"
 	"github.com/xzeus/cqrs/ioc"
-	"log"
 	"strings"
 )
 
@@ -13,9 +14,10 @@ type ViewByStringFunc func(deps ioc.Dependencies, v string) (interface{}, error)
 type ViewByStringsFunc func(deps ioc.Dependencies, q []string) (interface{}, error)
 type ViewByStringMapFunc func(deps ioc.Dependencies, q map[string][]string) (interface{}, error)
 type ViewByPageLoaderFunc func(deps ioc.Dependencies, page, offset int64) (interface{}, error)
+type ViewByCountLoaderFunc func(deps ioc.Dependencies, count, offset int64) (interface{}, error)
 type ViewByIdLoaderFunc func(deps ioc.Dependencies, id int64) (interface{}, error)
 
-func Publish(req Request, id int64, p cqrs.MessageDefiner, mods ...func(*cqrs.MessageOptionsDef)) {
+func Publish(req Request, id int64, p cqrs.MessageDefiner, mods ...func(*cqrs.MessageOptionsDef)) (cqrs.Message, error) {
 	deps := req.Deps()
 	d := p.Domain()
 	o := cqrs.NewMessageOptions(id, 0, 0)
@@ -23,34 +25,106 @@ func Publish(req Request, id int64, p cqrs.MessageDefiner, mods ...func(*cqrs.Me
 		mod(o)
 	}
 	c := cqrs.NewMessage(o.Id(), o.Version(), o.Timestamp(), cqrs.NoOrigin, p)
-	d.Handler(deps, c)
+	c = cqrs.WithTraceParent(c, req.Trace().TraceParent())
+	return d.HandlerContext(req.Request().Context(), deps, c)
 }
 
+// CommandResult is the body of a synchronous command's response
+type CommandResult struct {
+	Domain    string      `json:"domain"`
+	Type      string      `json:"type"`
+	Name      string      `json:"name"`
+	Id        string      `json:"id"`
+	Version   int32       `json:"version"`
+	Timestamp int64       `json:"timestamp"`
+	Payload   interface{} `json:"payload"`
+}
+
+// NewCommandResult describes the event, the payload is left as the raw
+// message data if the event's domain isn't registered
+func NewCommandResult(event cqrs.Message) *CommandResult {
+	r := &CommandResult{
+		Type:      Hex32(int32(event.GetMessageType())),
+		Id:        Hex64(event.GetId()),
+		Version:   event.GetVersion(),
+		Timestamp: event.GetTimestamp(),
+		Payload:   event.GetData(),
+	}
+	if m, found := domains.Meta().Domains[event.GetDomainId()]; found {
+		r.Domain = m.Uri
+		if p, err := m.Domain.LookupMessage(event.GetMessageType()); err == nil && cqrs.Extract(p, event) == nil {
+			r.Name = m.Domain.MessageName(p)
+			r.Payload = p
+		}
+	}
+	return r
+}
+
+// CommandHandler publishes the command and responds 202 without waiting on
+// the event handlers
 func CommandHandler(m cqrs.MessageDefiner, requireBody bool) ApiFunc {
+	return commandHandler(m, requireBody, false)
+}
+
+// SyncCommandHandler publishes the command and responds with the resulting
+// event, error events are mapped to the status matching their code
+func SyncCommandHandler(m cqrs.MessageDefiner, requireBody bool) ApiFunc {
+	return commandHandler(m, requireBody, true)
+}
+
+func commandHandler(m cqrs.MessageDefiner, requireBody bool, sync bool) ApiFunc {
+	d := m.Domain()
+	message_type := d.MessageType(m)
 	return func(req Request, resp Response) {
+		m := d.Message(message_type) // Fresh payload so prior requests don't leak fields
 		var id int64
+		log := req.Deps().Logger()
 		if t, err := req.GetToken("session"); err != nil {
-			log.Printf("No session token found so one was generated")
+			log.Debugf("No session token found so one was generated")
 			id = req.Deps().Crypto().RandInt64()
 		} else { // Should have been verified by middleware if required
-			if err != nil {
-				log.Printf("Token err [ %s ]", err)
-				resp.Error("invalid session token", 40110, 500, err)
-				return
-			}
 			session_id := t.GetId()
-			log.Printf("Session [ %s ]", session_id)
-			if id, err = ParseInt(session_id, 16, 64); err != nil {
+			if id, err = Int64(session_id); err != nil { // Same hex as Session issues
+				log.With(ioc.Fields{ioc.FieldError: err}).Warnf("invalid session id [ %s ]", session_id)
 				resp.Error(fmt.Sprintf("invalid session id [ %s ]", t.GetId()), 40040, 500, err)
 				return
 			}
 		}
-		log.Printf("Session Id [ %X ]", uint64(id))
+		log = log.With(ioc.Fields{
+			ioc.FieldDomain:      d.Uri(),
+			ioc.FieldAggregateId: Hex64(id),
+			ioc.FieldMessage:     d.MessageName(m),
+		})
+		log.Debugf("command received")
 		if err := req.Json(m); requireBody && err != nil {
 			resp.Error(fmt.Sprintf("invalid request: [ %s ]", err), 40030, 500, err)
 			return
 		}
-		Publish(req, id, m)
+		if errs := cqrs.Validate(m); len(errs) > 0 {
+			resp.Invalid(errs)
+			return
+		}
+		result, err := Publish(req, id, m)
+		if err != nil { // Faults answer the same whether or not the caller waits
+			log.With(ioc.Fields{ioc.FieldError: err}).Warnf("command failed")
+			resp.HandlerError(err)
+			return
+		}
+		if sync {
+			if e, ok := exception.AsEvent(result); ok {
+				log.Debugf("command rejected [ %d ]", e.GetDetails().Code)
+				resp.Exception(e)
+				return
+			}
+		}
+		if e, ok := exception.AsValidationFailed(result); ok {
+			resp.Invalid(e.Fields)
+			return
+		}
+		if sync {
+			resp.Json(NewCommandResult(result), 200)
+			return
+		}
 		resp.Empty(202)
 	}
 }
@@ -67,6 +141,16 @@ func Domain(d cqrs.Domain, mods ...func(RouteNodeHandler)) func(RouteNode) {
 	}
 }
 
+// SyncDomain defines a synchronous endpoint for each of the domain's commands
+func SyncDomain(d cqrs.Domain, mods ...func(RouteNodeHandler)) func(RouteNode) {
+	return func(r RouteNode) {
+		for _, f := range d.Commands() {
+			c := f()
+			SyncCommand(c, true, mods...)(r)
+		}
+	}
+}
+
 func Command(c cqrs.MessageDefiner, requireBody bool, mods ...func(RouteNodeHandler)) func(RouteNode) {
 	d := c.Domain()
 	n := strings.ToLower(d.MessageName(c))
@@ -76,6 +160,15 @@ func Command(c cqrs.MessageDefiner, requireBody bool, mods ...func(RouteNodeHand
 	}
 }
 
+func SyncCommand(c cqrs.MessageDefiner, requireBody bool, mods ...func(RouteNodeHandler)) func(RouteNode) {
+	d := c.Domain()
+	n := strings.ToLower(d.MessageName(c))
+	return func(r RouteNode) {
+		h := NewHandler(n, []string{"POST", "OPTIONS"}, SyncCommandHandler(c, requireBody), mods...)
+		r.AppendHandler(h)
+	}
+}
+
 func View(path string, handler ApiFunc, mods ...func(RouteNodeHandler)) func(RouteNode) {
 	return func(r RouteNode) {
 		h := NewHandler(path, []string{"GET"}, handler, mods...)
@@ -89,26 +182,33 @@ func NoParams(f ViewFunc) ApiFunc {
 		resp.View(v, err)
 	}
 }
+
+// ByPage loads the view with the zero based page number and the offset
+// parsed by ExtractPage
 func ByPage(f ViewByPageLoaderFunc) ApiFunc {
-	return func(req Request, resp Response) {
-		var page int64 = 0
-		var offset int64 = 0
-		v, err := f(req.Deps(), page, offset)
-		resp.View(v, err)
-	}
+	return ByCount(func(deps ioc.Dependencies, count, offset int64) (interface{}, error) {
+		return f(deps, offset/count, offset)
+	})
 }
 
-func ByIdFromPath(f ViewByIdLoaderFunc) ApiFunc {
+// ByCount loads the view with the count and offset parsed by ExtractPage
+func ByCount(f ViewByCountLoaderFunc) ApiFunc {
 	return func(req Request, resp Response) {
-		if id, found := req.ExtractInt64ElementId(); !found {
-			resp.Error("id not found in request", 40030, 404, nil)
-		} else {
-			v, err := f(req.Deps(), id)
-			resp.View(v, err)
+		count, offset := ExtractPage(req.Request().URL, DefaultPageSize, 0)
+		if count > MaxPageSize {
+			count = MaxPageSize
 		}
+		v, err := f(req.Deps(), int64(count), int64(offset))
+		resp.View(v, err)
 	}
 }
 
+// ByIdFromPath loads the view by the named hex id parameter as
+// ByIdFromParam does
+func ByIdFromPath(name string, f ViewByIdLoaderFunc) ApiFunc {
+	return ByIdFromParam(name, f)
+}
+
 func ByStringFromPath(f ViewByStringFunc) ApiFunc {
 	return func(req Request, resp Response) {
 		if v, found := req.ExtractStringElementId(); !found {
@@ -0,0 +1,142 @@
+package apiserver_test
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/xzeus/cqrs"
+	. "github.com/xzeus/cqrs/apiserver"
+	"github.com/xzeus/cqrs/domains"
+	"github.com/xzeus/cqrs/ioc"
+	. "github.com/xzeus/cqrs/testing"
+	"github.com/xzeus/cqrs/testing/testdeps"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type ticket struct{}
+
+func (a *ticket) Init() cqrs.AggregateState  { return &ticket{} }
+func (a *ticket) Handle(cqrs.MessageDefiner) {}
+
+type ticketDefiner struct{}
+
+func (ticketDefiner) Domain() cqrs.Domain { return ticketDomain }
+
+type Open struct {
+	cqrs.JsonSerialized
+	ticketDefiner
+	Title string `json:"title" validate:"required"`
+}
+
+type Opened struct {
+	cqrs.JsonSerialized
+	ticketDefiner
+	Title string `json:"title"`
+}
+
+var (
+	ticketDomain = domains.NewDomain(ticketDefiner{}, "github.com/xzeus/cqrs/apiserver/test/ticket/v1", &ticket{})
+
+	C_Open = ticketDomain.DefCommand(1, 1, &Open{}, func(h cqrs.CommandHandlerDef) cqrs.CommandHandlerFunc {
+		return func(_ cqrs.AggregateHeader, _ cqrs.AggregateState, _ cqrs.Message, p cqrs.MessageDefiner) {
+			title := p.(*Open).Title
+			if title == "missing" {
+				h.Reject(ioc.CodeNotFound, "no such board")
+				return
+			}
+			if title == "broken" {
+				h.Reject(ioc.CodeInternal, "board table [ %s ] is corrupt", "boards_v1")
+				return
+			}
+			h.Publish(&Opened{Title: title})
+		}
+	})
+
+	E_Opened = ticketDomain.DefEvent(1, 1, &Opened{})
+)
+
+func postOpen(t *testing.T, body string) *http.Response {
+	s, err := NewServer(testProvider(testdeps.NewDependencies()))
+	Ok(t, err)
+	s.Define(SyncCommand(&Open{}, true))
+	test_server := httptest.NewServer(s.BuildRouter())
+	defer test_server.Close()
+	res, err := http.Post(test_server.URL+"/open", "application/json", strings.NewReader(body))
+	Ok(t, err)
+	return res
+}
+
+func Test_Should_respond_with_resulting_event(t *testing.T) {
+	res := postOpen(t, `{"title":"first"}`)
+	defer res.Body.Close()
+	Equals(t, 200, res.StatusCode, "should respond synchronously")
+	result := struct {
+		Name    string `json:"name"`
+		Version int32  `json:"version"`
+		Payload Opened `json:"payload"`
+	}{}
+	Ok(t, json.NewDecoder(res.Body).Decode(&result))
+	Equals(t, "Opened", result.Name, "should name the event")
+	Equals(t, int32(1), result.Version, "should include the new version")
+	Equals(t, "first", result.Payload.Title, "should include the payload")
+}
+
+func Test_Should_map_rejection_code_to_status(t *testing.T) {
+	res := postOpen(t, `{"title":"missing"}`)
+	res.Body.Close()
+	Equals(t, 404, res.StatusCode, "should use the rejection's code")
+}
+
+func Test_Should_hide_internal_rejection_detail(t *testing.T) {
+	res := postOpen(t, `{"title":"broken"}`)
+	defer res.Body.Close()
+	Equals(t, 500, res.StatusCode, "should fail the request")
+	result := struct {
+		Message string `json:"message"`
+		Code    int    `json:"code"`
+	}{}
+	Ok(t, json.NewDecoder(res.Body).Decode(&result))
+	Equals(t, "Internal server error", result.Message, "should not leak the detail")
+	Equals(t, ErrorCodeInternal, result.Code, "should use the named code")
+}
+
+// appDeps binds itself to a context so handlers can assert it's type
+type appDeps struct {
+	*testdeps.Dependencies
+	ctx context.Context
+}
+
+func (d *appDeps) WithContext(ctx context.Context) ioc.ContextDependencies {
+	return &appDeps{d.Dependencies, ctx}
+}
+
+func (d *appDeps) Context() context.Context { return d.ctx }
+
+func Test_Should_pass_custom_dependencies_to_the_handler(t *testing.T) {
+	app_ok, unwrap_ok := false, false
+	s, err := NewServer(testProvider(&appDeps{Dependencies: testdeps.NewDependencies()}))
+	Ok(t, err)
+	s.Define(View("app", func(req Request, resp Response) {
+		d, ok := req.Deps().(*appDeps)
+		app_ok = ok && d.Context() == req.Request().Context()
+		resp.Empty(204)
+	}))
+	plain, err := NewServer(testProvider(testdeps.NewDependencies()))
+	Ok(t, err)
+	plain.Define(View("plain", func(req Request, resp Response) {
+		_, unwrap_ok = ioc.Unwrap(req.Deps()).(*testdeps.Dependencies)
+		resp.Empty(204)
+	}))
+	for path, s := range map[string]Server{"/app": s, "/plain": plain} {
+		test_server := httptest.NewServer(s.BuildRouter())
+		res, err := http.Get(test_server.URL + path)
+		Ok(t, err)
+		res.Body.Close()
+		test_server.Close()
+		Equals(t, 204, res.StatusCode, "should have served [ %s ]", path)
+	}
+	Assert(t, app_ok, "should keep the bound dependencies' type")
+	Assert(t, unwrap_ok, "should unwrap wrapped dependencies")
+}
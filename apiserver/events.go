@@ -0,0 +1,244 @@
+package apiserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/xzeus/cqrs"
+	"github.com/xzeus/cqrs/ioc"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const LastEventId = "Last-Event-ID"
+
+// EventStreamConfig describes who may follow an event stream and which
+// events it carries
+type EventStreamConfig struct {
+	// Tokens are loaded for each stream, the first key's token is required
+	// unless Anonymous is set
+	Tokens    TokenConfig
+	Anonymous bool
+	// Types limits the stream to the listed event types, empty sends all
+	Types []cqrs.MessageType
+	// Authorize is checked for each event, nil sends all
+	Authorize func(Request, cqrs.Message) bool
+	// Heartbeat is the interval of the comments which keep idle connections
+	// open, defaults to 15 seconds
+	Heartbeat time.Duration
+}
+
+// AggregateEvents defines GET {path}/{id} streaming the aggregate's events
+// as server sent events
+func AggregateEvents(path string, d cqrs.Domain, config EventStreamConfig, mods ...func(RouteNodeHandler)) func(RouteNode) {
+	return func(r RouteNode) {
+		r.AppendHandler(NewHandler(path+"/"+URL_HEX_ID, []string{"GET"}, eventStream(d, config, true), mods...))
+	}
+}
+
+// DomainEvents defines GET {path} streaming every aggregate's events for the
+// domain as server sent events
+func DomainEvents(path string, d cqrs.Domain, config EventStreamConfig, mods ...func(RouteNodeHandler)) func(RouteNode) {
+	return func(r RouteNode) {
+		r.AppendHandler(NewHandler(path, []string{"GET"}, eventStream(d, config, false), mods...))
+	}
+}
+
+// eventId is the timestamp, aggregate id and version of the event so that
+// either an aggregate or domain stream can resume from it
+func eventId(m cqrs.Message) string {
+	return fmt.Sprintf("%d-%s-%d", m.GetTimestamp(), Hex64(m.GetId()), m.GetVersion())
+}
+
+func parseEventId(event_id string) (ts int64, id int64, version int32, ok bool) {
+	parts := strings.Split(event_id, "-")
+	if len(parts) != 3 {
+		return 0, 0, 0, false
+	}
+	ts, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return 0, 0, 0, false
+	}
+	if id, err = Int64(parts[1]); err != nil {
+		return 0, 0, 0, false
+	}
+	v, err := strconv.ParseInt(parts[2], 10, 32)
+	return ts, id, int32(v), err == nil
+}
+
+func eventKey(m cqrs.Message) string {
+	return fmt.Sprintf("%X|%X|%d", uint32(m.GetDomainId()), uint64(m.GetId()), m.GetVersion())
+}
+
+// eventFilter selects the events of a stream
+type eventFilter struct {
+	domain    cqrs.Domain
+	aggregate bool
+	id        int64
+	types     map[cqrs.MessageType]bool
+	authorize func(Request, cqrs.Message) bool
+}
+
+func newEventFilter(d cqrs.Domain, aggregate bool, id int64, types []cqrs.MessageType, authorize func(Request, cqrs.Message) bool) eventFilter {
+	f := eventFilter{domain: d, aggregate: aggregate, id: id, types: make(map[cqrs.MessageType]bool), authorize: authorize}
+	for _, t := range types {
+		f.types[t] = true
+	}
+	return f
+}
+
+// matches is cheap enough to run within the publisher's subscription filter
+func (f eventFilter) matches(m cqrs.Message) bool {
+	return m.GetDomainId() == f.domain.Id() &&
+		(!f.aggregate || m.GetId() == f.id) &&
+		(len(f.types) == 0 || f.types[m.GetMessageType()])
+}
+
+func (f eventFilter) authorized(req Request, m cqrs.Message) bool {
+	return f.authorize == nil || f.authorize(req, m)
+}
+
+// replay loads the accepted events stored after the last event id in the
+// order they were appended, an empty or invalid id replays nothing
+func (f eventFilter) replay(req Request, last string) ([]cqrs.Message, error) {
+	ts, id, version, ok := parseEventId(last)
+	if !ok {
+		return []cqrs.Message{}, nil
+	}
+	var events []cqrs.Message
+	var err error
+	if f.aggregate {
+		events, err = req.Deps().EventStore().GetAggregateEvents(f.domain.Id(), f.id, version+1)
+	} else { // From the same timestamp as others may share it
+		events, err = req.Deps().EventStore().GetDomainEvents(f.domain.Id(), ts, 0)
+	}
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(events, func(i, j int) bool { return events[i].GetTimestamp() < events[j].GetTimestamp() })
+	if !f.aggregate {
+		for i, m := range events {
+			if m.GetTimestamp() != ts {
+				break
+			}
+			if m.GetId() == id && m.GetVersion() == version { // Those up to it were sent
+				events = events[i+1:]
+				break
+			}
+		}
+	}
+	r := make([]cqrs.Message, 0, len(events))
+	for _, m := range events {
+		if f.matches(m) && f.authorized(req, m) {
+			r = append(r, m)
+		}
+	}
+	return r, nil
+}
+
+// follow subscribes to the accepted events before replaying those since the
+// last event id so that nothing falls between, start is called once the
+// replay is loaded then send for each event in order until it fails, done is
+// closed, the server is stopping or the subscription falls behind
+func (f eventFilter) follow(req Request, subscriber ioc.Subscriber, last string, start func() error, send func(cqrs.Message) error, beat func() error, done <-chan struct{}, heartbeat <-chan time.Time) error {
+	live, cancel := subscriber.Subscribe(f.matches) // Authorized here rather than under the publisher's lock
+	defer cancel()
+	replay, err := f.replay(req, last)
+	if err != nil {
+		return err
+	}
+	if err := start(); err != nil {
+		return err
+	}
+	sent := make(map[string]bool)
+	for _, m := range replay {
+		if err := send(m); err != nil {
+			return err
+		}
+		sent[eventKey(m)] = true
+	}
+	for {
+		select {
+		case <-done:
+			return nil
+		case <-Stopping(req.Request()): // Clients reconnect to another server
+			return nil
+		case m, open := <-live:
+			if !open { // Fell behind, the client resumes from it's last event
+				return nil
+			}
+			if sent[eventKey(m)] || !f.authorized(req, m) {
+				continue
+			}
+			if err := send(m); err != nil {
+				return err
+			}
+		case <-heartbeat:
+			if err := beat(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func eventStream(d cqrs.Domain, config EventStreamConfig, aggregate bool) ApiFunc {
+	heartbeat := config.Heartbeat
+	if heartbeat <= 0 {
+		heartbeat = 15 * time.Second
+	}
+	stream := func(req Request, resp Response) {
+		var id int64
+		if aggregate {
+			var err error
+			if id, err = req.HexParam("id"); err != nil { // Routed with URL_HEX_ID
+				ParamError(resp, "id", err)
+				return
+			}
+		}
+		subscriber, ok := req.Deps().Publisher().(ioc.Subscriber)
+		if !ok {
+			resp.Error("event streams not supported", 50110, 501, nil)
+			return
+		}
+		filter := newEventFilter(d, aggregate, id, config.Types, config.Authorize)
+		last := req.Request().Header.Get(LastEventId)
+		if last == "" {
+			last = req.Request().URL.Query().Get("lastEventId")
+		}
+		var w *StreamWriter
+		ticker := time.NewTicker(heartbeat)
+		defer ticker.Stop()
+		err := filter.follow(req, subscriber, last, func() (err error) {
+			resp.Recorder().Header().Set("Cache-Control", "no-cache")
+			resp.Recorder().Header().Set("X-Accel-Buffering", "no") // Keep proxies from buffering
+			w, err = resp.Stream("text/event-stream", 200)
+			return
+		}, func(m cqrs.Message) error {
+			result := NewCommandResult(m)
+			data, err := json.Marshal(result)
+			if err != nil {
+				return err
+			}
+			name := result.Name
+			if name == "" {
+				name = "message"
+			}
+			_, err = fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", eventId(m), name, data)
+			return err
+		}, func() error {
+			_, err := fmt.Fprint(w, ": heartbeat\n\n")
+			return err
+		}, req.Request().Context().Done(), ticker.C)
+		if err != nil && w == nil { // Failed before the stream started
+			resp.Error("unable to resume event stream", 50030, 503, err)
+		} else if err != nil {
+			req.Deps().Logger().With(ioc.Fields{ioc.FieldError: err}).Debugf("event stream closed")
+		}
+	}
+	h := stream
+	if !config.Anonymous {
+		h = RequireToken(config.Tokens.keys()[0])(h)
+	}
+	return LoadTokens(config.Tokens)(h)
+}
@@ -0,0 +1,114 @@
+package apiserver_test
+
+import (
+	"bufio"
+	"github.com/xzeus/cqrs"
+	. "github.com/xzeus/cqrs/apiserver"
+	"github.com/xzeus/cqrs/ioc"
+	. "github.com/xzeus/cqrs/testing"
+	"github.com/xzeus/cqrs/testing/testdeps"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type hubDependencies struct {
+	*testdeps.Dependencies
+	hub *ioc.PublisherHub
+}
+
+func (d *hubDependencies) Publisher() ioc.Publisher { return d.hub }
+
+func Test_Should_resume_and_follow_aggregate_events(t *testing.T) {
+	deps := &hubDependencies{testdeps.NewDependencies(), nil}
+	deps.hub = ioc.NewPublisherHub(deps.Mock_Publisher)
+	store := deps.EventStore()
+	_, err := store.AppendEvent(0x10, 1, []cqrs.AggregateHeader{}, &Opened{Title: "first"})
+	Ok(t, err)
+	s, err := NewServer(testProvider(deps))
+	Ok(t, err)
+	s.Define(AggregateEvents("tickets", ticketDomain, EventStreamConfig{Anonymous: true}))
+	test_server := httptest.NewServer(s.BuildRouter())
+	defer test_server.Close()
+
+	r, _ := http.NewRequest("GET", test_server.URL+"/tickets/10", nil)
+	r.Header.Set("Last-Event-ID", "0-10-0")
+	res, err := http.DefaultClient.Do(r)
+	Ok(t, err)
+	defer res.Body.Close()
+	Equals(t, "text/event-stream", res.Header.Get("Content-Type"), "should stream events")
+	lines := bufio.NewReader(res.Body)
+	next := func() string {
+		for {
+			line, err := lines.ReadString('\n')
+			Ok(t, err)
+			if strings.HasPrefix(line, "data: ") {
+				return line
+			}
+		}
+	}
+	Assert(t, strings.Contains(next(), `"title":"first"`), "should replay the stored event")
+	e, err := store.AppendEvent(0x10, 2, []cqrs.AggregateHeader{}, &Opened{Title: "second"})
+	Ok(t, err)
+	other, _ := store.AppendEvent(0x11, 1, []cqrs.AggregateHeader{}, &Opened{Title: "other"})
+	deps.hub.Publish(other)
+	deps.hub.Publish(e)
+	Assert(t, strings.Contains(next(), `"title":"second"`), "should push the live event for the aggregate")
+	Equals(t, 2, len(deps.Mock_Publisher.Published), "should pass events on to the next publisher")
+}
+
+func Test_Should_resume_domain_events_sharing_a_timestamp(t *testing.T) {
+	deps := &hubDependencies{testdeps.NewDependencies(), ioc.NewPublisherHub(nil)}
+	store := deps.EventStore()
+	deps.Mock_EventStore.Now = func() int64 { return 5000 }
+	_, err := store.AppendEvent(0x40, 1, []cqrs.AggregateHeader{}, &Opened{Title: "sent"})
+	Ok(t, err)
+	_, err = store.AppendEvent(0x41, 1, []cqrs.AggregateHeader{}, &Opened{Title: "missed"})
+	Ok(t, err)
+	s, err := NewServer(testProvider(deps))
+	Ok(t, err)
+	s.Define(DomainEvents("tickets", ticketDomain, EventStreamConfig{
+		Anonymous: true,
+		Authorize: func(req Request, m cqrs.Message) bool {
+			return m.GetId() != 0x42
+		},
+	}))
+	test_server := httptest.NewServer(s.BuildRouter())
+	defer test_server.Close()
+
+	r, _ := http.NewRequest("GET", test_server.URL+"/tickets", nil)
+	r.Header.Set("Last-Event-ID", "5000-40-1")
+	res, err := http.DefaultClient.Do(r)
+	Ok(t, err)
+	defer res.Body.Close()
+	lines := bufio.NewReader(res.Body)
+	next := func() string {
+		for {
+			line, err := lines.ReadString('\n')
+			Ok(t, err)
+			if strings.HasPrefix(line, "data: ") {
+				return line
+			}
+		}
+	}
+	Assert(t, strings.Contains(next(), `"title":"missed"`), "should replay the event sharing the timestamp")
+	hidden, _ := store.AppendEvent(0x42, 1, []cqrs.AggregateHeader{}, &Opened{Title: "hidden"})
+	shown, _ := store.AppendEvent(0x43, 1, []cqrs.AggregateHeader{}, &Opened{Title: "shown"})
+	deps.hub.Publish(hidden)
+	deps.hub.Publish(shown)
+	Assert(t, strings.Contains(next(), `"title":"shown"`), "should skip the unauthorized live event")
+}
+
+func Test_Should_require_session_for_event_stream(t *testing.T) {
+	deps := &hubDependencies{testdeps.NewDependencies(), ioc.NewPublisherHub(nil)}
+	s, err := NewServer(testProvider(deps))
+	Ok(t, err)
+	s.Define(DomainEvents("tickets", ticketDomain, EventStreamConfig{}))
+	test_server := httptest.NewServer(s.BuildRouter())
+	defer test_server.Close()
+	res, err := http.Get(test_server.URL + "/tickets")
+	Ok(t, err)
+	res.Body.Close()
+	Equals(t, 401, res.StatusCode, "should reject streams without a session")
+}
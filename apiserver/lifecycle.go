@@ -0,0 +1,288 @@
+package apiserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/xzeus/cqrs"
+	"github.com/xzeus/cqrs/domains"
+	"github.com/xzeus/cqrs/ioc"
+	"net"
+	"net/http"
+	"time"
+)
+
+var (
+	ErrServerRunning    = errors.New("server already running")
+	ErrServerNotRunning = errors.New("server not running")
+)
+
+const (
+	DefaultLivenessPath  = "/healthz"
+	DefaultReadinessPath = "/readyz"
+)
+
+const (
+	HealthOk          = "ok"
+	HealthDraining    = "draining"
+	HealthUnavailable = "unavailable"
+)
+
+// Runner is a background service such as an event subscription which is
+// started with the server, Run should return once ctx is cancelled and
+// anything it was delivering has drained
+type Runner interface {
+	Run(ctx context.Context) error
+}
+
+type RunnerFunc func(ctx context.Context) error
+
+func (f RunnerFunc) Run(ctx context.Context) error {
+	return f(ctx)
+}
+
+// HealthCheck returns why the server shouldn't be sent traffic
+type HealthCheck func(ctx context.Context, deps ioc.Dependencies) error
+
+type healthCheck struct {
+	name  string
+	check HealthCheck
+}
+
+// HealthStatus is the body of the liveness and readiness endpoints
+type HealthStatus struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks,omitempty"`
+}
+
+type stoppingKey struct{}
+
+// Stopping is closed once the server handling the request begins shutting
+// down, long lived streams end when it is rather than hold up the drain
+func Stopping(r *http.Request) <-chan struct{} {
+	if c, ok := r.Context().Value(stoppingKey{}).(chan struct{}); ok {
+		return c
+	}
+	return nil // Never closed when served some other way
+}
+
+func Runners(runners ...Runner) ServerModifier {
+	return func(s Server) {
+		for _, r := range runners {
+			s.AddRunner(r)
+		}
+	}
+}
+
+func ReadinessCheck(name string, check HealthCheck) ServerModifier {
+	return func(s Server) {
+		s.AddCheck(name, check)
+	}
+}
+
+// HealthPaths moves the liveness and readiness endpoints, an empty path
+// leaves that endpoint out
+func HealthPaths(liveness, readiness string) ServerModifier {
+	return func(s Server) {
+		s.SetHealthPaths(liveness, readiness)
+	}
+}
+
+// DrainDelay keeps serving for the delay after readiness starts failing on
+// Shutdown so that load balancers stop routing before listeners close
+func DrainDelay(delay time.Duration) ServerModifier {
+	return func(s Server) {
+		s.SetDrainDelay(delay)
+	}
+}
+
+// RequireHandlers fails Serve while any command of the served domains has no
+// handler, domains the server doesn't serve are left to their own process
+func RequireHandlers(served ...cqrs.Domain) ServerModifier {
+	return func(s Server) {
+		s.RequireHandlers(served...)
+	}
+}
+
+// EventStoreCheck pings event stores implementing ioc.EventStorePinger and
+// otherwise queries an empty period
+func EventStoreCheck() HealthCheck {
+	return func(ctx context.Context, deps ioc.Dependencies) error {
+		store := deps.EventStore()
+		if pinger, ok := store.(ioc.EventStorePinger); ok {
+			return pinger.Ping(ctx)
+		}
+		now := deps.Time().Now()
+		_, err := store.GetDomainEvents(0, now, now)
+		return err
+	}
+}
+
+func (s *ServerDef) AddRunner(r Runner) {
+	s.runners = append(s.runners, r)
+}
+
+func (s *ServerDef) AddCheck(name string, check HealthCheck) {
+	s.checks = append(s.checks, healthCheck{name: name, check: check})
+}
+
+func (s *ServerDef) SetHealthPaths(liveness, readiness string) {
+	s.liveness_path = liveness
+	s.readiness_path = readiness
+}
+
+func (s *ServerDef) SetDrainDelay(delay time.Duration) {
+	s.drain_delay = delay
+}
+
+func (s *ServerDef) RequireHandlers(served ...cqrs.Domain) {
+	s.required = append(s.required, served...)
+}
+
+// ListenAndServe serves on the tcp address until Shutdown
+func (s *ServerDef) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Serve starts the runners then serves the router on the listener until
+// Shutdown has drained both, a server is only served once and fails to start
+// while a required domain has a command without a handler
+func (s *ServerDef) Serve(l net.Listener) error {
+	if len(s.required) > 0 {
+		if err := domains.Meta().Validate(s.required...); err != nil {
+			l.Close()
+			return err
+		}
+	}
+	s.lock.Lock()
+	if s.running || s.stopped != nil {
+		s.lock.Unlock()
+		l.Close()
+		return ErrServerRunning
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	s.running = true
+	s.stopped = make(chan struct{})
+	s.cancel_runners = cancel
+	s.http_server = &http.Server{
+		Handler: s.BuildRouter(),
+		BaseContext: func(net.Listener) context.Context {
+			return context.WithValue(context.Background(), stoppingKey{}, s.stopping)
+		},
+	}
+	s.lock.Unlock()
+	for _, r := range s.runners {
+		s.runners_wg.Add(1)
+		go func(r Runner) {
+			defer s.runners_wg.Done()
+			if err := r.Run(ctx); err != nil && ctx.Err() == nil { // Stopped early
+				s.lock.Lock()
+				s.runner_errs = append(s.runner_errs, err)
+				s.lock.Unlock()
+			}
+		}(r)
+	}
+	if err := s.http_server.Serve(l); err != http.ErrServerClosed {
+		cancel()
+		s.runners_wg.Wait()
+		s.lock.Lock()
+		s.running = false
+		close(s.stopped)
+		s.lock.Unlock()
+		return err
+	}
+	<-s.stopped // Shutdown returns the outcome of the drain
+	return s.shutdown_err
+}
+
+// Shutdown fails readiness, waits out the drain delay, stops accepting
+// connections and waits for in flight requests before stopping the runners
+// and waiting for them to drain, streams are ended and ctx bounds the whole
+// drain
+func (s *ServerDef) Shutdown(ctx context.Context) error {
+	s.lock.Lock()
+	if !s.running {
+		s.lock.Unlock()
+		return ErrServerNotRunning
+	}
+	s.running = false
+	close(s.stopping)
+	s.lock.Unlock()
+	if s.drain_delay > 0 {
+		select {
+		case <-time.After(s.drain_delay):
+		case <-ctx.Done():
+		}
+	}
+	err := s.http_server.Shutdown(ctx)
+	s.cancel_runners()
+	drained := make(chan struct{})
+	go func() {
+		s.runners_wg.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		if err == nil {
+			err = ctx.Err()
+		}
+	}
+	s.shutdown_err = err
+	close(s.stopped)
+	return err
+}
+
+func (s *ServerDef) draining() bool {
+	select {
+	case <-s.stopping:
+		return true
+	default:
+		return false
+	}
+}
+
+func writeHealth(w http.ResponseWriter, status HealthStatus, code int) {
+	resp := NewResponse(w)
+	resp.Header().Set("Cache-Control", "no-store")
+	resp.Json(status, code)
+	resp.Flush()
+}
+
+// liveness answers for as long as the process can serve requests
+func (s *ServerDef) liveness(w http.ResponseWriter, r *http.Request) {
+	writeHealth(w, HealthStatus{Status: HealthOk}, 200)
+}
+
+// readiness runs each check and fails while draining so that load balancers
+// stop sending traffic ahead of the shutdown
+func (s *ServerDef) readiness(w http.ResponseWriter, r *http.Request) {
+	deps := ioc.WithContext(r.Context(), s.DepsProvider()(r)())
+	status := HealthStatus{Status: HealthOk, Checks: make(map[string]string)}
+	for _, c := range s.checks {
+		if err := c.check(r.Context(), deps); err != nil {
+			status.Status = HealthUnavailable
+			status.Checks[c.name] = err.Error()
+		} else {
+			status.Checks[c.name] = HealthOk
+		}
+	}
+	s.lock.Lock()
+	for i, err := range s.runner_errs {
+		status.Status = HealthUnavailable
+		status.Checks[fmt.Sprintf("runner.%d", i)] = err.Error()
+	}
+	s.lock.Unlock()
+	if s.draining() {
+		status.Status = HealthDraining
+	}
+	if status.Status != HealthOk {
+		writeHealth(w, status, 503)
+		return
+	}
+	writeHealth(w, status, 200)
+}
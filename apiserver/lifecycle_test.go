@@ -0,0 +1,204 @@
+package apiserver_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/xzeus/cqrs"
+	. "github.com/xzeus/cqrs/apiserver"
+	"github.com/xzeus/cqrs/domains"
+	"github.com/xzeus/cqrs/ioc"
+	. "github.com/xzeus/cqrs/testing"
+	"github.com/xzeus/cqrs/testing/testdeps"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_Should_report_liveness_and_failing_readiness(t *testing.T) {
+	s, err := NewServer(testProvider(testdeps.NewDependencies()))
+	Ok(t, err)
+	s.Config(
+		ReadinessCheck("event_store", EventStoreCheck()),
+		ReadinessCheck("broken", func(context.Context, ioc.Dependencies) error { return errors.New("unreachable") }),
+	)
+	test_server := httptest.NewServer(s.BuildRouter())
+	defer test_server.Close()
+	res, err := http.Get(test_server.URL + DefaultLivenessPath)
+	Ok(t, err)
+	res.Body.Close()
+	Equals(t, 200, res.StatusCode, "should be live")
+	res, err = http.Get(test_server.URL + DefaultReadinessPath)
+	Ok(t, err)
+	status := HealthStatus{}
+	Ok(t, json.NewDecoder(res.Body).Decode(&status))
+	res.Body.Close()
+	Equals(t, 503, res.StatusCode, "should not be ready")
+	Equals(t, HealthStatus{Status: HealthUnavailable, Checks: map[string]string{
+		"event_store": HealthOk,
+		"broken":      "unreachable",
+	}}, status, "should report each check")
+}
+
+func Test_Should_drain_requests_and_subscriptions_on_shutdown(t *testing.T) {
+	deps := testdeps.NewDependencies()
+	hub := ioc.NewPublisherHub(nil)
+	release := make(chan struct{})
+	var lock sync.Mutex
+	handled := 0
+	runner := &SubscriptionRunner{Name: "tickets", Subscriber: hub, Handle: func(ctx context.Context, m cqrs.Message) {
+		<-release
+		lock.Lock()
+		handled++
+		lock.Unlock()
+	}}
+	s, err := NewServer(testProvider(deps))
+	Ok(t, err)
+	s.Config(Runners(runner), ReadinessCheck("lag", SubscriptionLag(time.Hour, 0, runner)))
+	s.Define(View("slow", func(req Request, resp Response) {
+		<-release
+		resp.Text("done", 200)
+	}))
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	Ok(t, err)
+	served := make(chan error)
+	go func() { served <- s.Serve(l) }()
+	url := "http://" + l.Addr().String()
+	res, err := http.Get(url + DefaultReadinessPath)
+	Ok(t, err)
+	res.Body.Close()
+	Equals(t, 200, res.StatusCode, "should be ready")
+
+	for i := int32(1); i <= 3; i++ {
+		m, err := deps.EventStore().AppendEvent(0x20, i, []cqrs.AggregateHeader{}, &Opened{Title: "t"})
+		Ok(t, err)
+		hub.Publish(m)
+	}
+	in_flight := make(chan int)
+	go func() {
+		res, err := http.Get(url + "/slow")
+		Ok(t, err)
+		res.Body.Close()
+		in_flight <- res.StatusCode
+	}()
+	time.Sleep(50 * time.Millisecond) // Let the request arrive
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		close(release)
+	}()
+	Ok(t, s.Shutdown(context.Background()))
+	Equals(t, 200, <-in_flight, "should finish the in flight request")
+	Ok(t, <-served)
+	Equals(t, 3, handled, "should deliver the buffered messages")
+	Equals(t, ErrServerNotRunning, s.Shutdown(context.Background()), "should only shut down once")
+}
+
+// signalSubscriber closes subscribed once Subscribe has been called
+type signalSubscriber struct {
+	ioc.Subscriber
+	subscribed chan struct{}
+}
+
+func (s *signalSubscriber) Subscribe(filter func(cqrs.Message) bool) (<-chan cqrs.Message, func()) {
+	defer close(s.subscribed)
+	return s.Subscriber.Subscribe(filter)
+}
+
+func Test_Should_report_queued_subscription_messages(t *testing.T) {
+	deps := testdeps.NewDependencies()
+	hub := &signalSubscriber{ioc.NewPublisherHub(nil), make(chan struct{})}
+	release := make(chan struct{})
+	runner := &SubscriptionRunner{Name: "tickets", Subscriber: hub, Handle: func(ctx context.Context, m cqrs.Message) {
+		<-release
+	}}
+	ctx, cancel := context.WithCancel(context.Background())
+	ran := make(chan error)
+	go func() { ran <- runner.Run(ctx) }()
+	<-hub.subscribed
+	for i := int32(1); i <= 3; i++ {
+		m, err := deps.EventStore().AppendEvent(0x50, i, []cqrs.AggregateHeader{}, &Opened{Title: "t"})
+		Ok(t, err)
+		hub.Subscriber.(*ioc.PublisherHub).Publish(m)
+	}
+	for i := 0; runner.Queued() != 3 && i < 100; i++ {
+		time.Sleep(time.Millisecond) // Until the first is being handled
+	}
+	Equals(t, 3, runner.Queued(), "should count the handled and queued messages")
+	Ok(t, SubscriptionLag(time.Hour, 0, runner)(ctx, deps))
+	err := SubscriptionLag(time.Hour, 2, runner)(ctx, deps)
+	NotOk(t, err)
+	Assert(t, strings.Contains(err.Error(), "3 queued"), "should report the queued count [ %s ]", err)
+	close(release)
+	cancel()
+	Ok(t, <-ran)
+	Equals(t, 0, runner.Queued(), "should have drained the queue")
+}
+
+func Test_Should_keep_serving_through_the_drain_delay(t *testing.T) {
+	s, err := NewServer(testProvider(testdeps.NewDependencies()))
+	Ok(t, err)
+	s.Config(DrainDelay(200 * time.Millisecond))
+	s.Define(View("ping", func(req Request, resp Response) { resp.Empty(204) }))
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	Ok(t, err)
+	served := make(chan error)
+	go func() { served <- s.Serve(l) }()
+	url := "http://" + l.Addr().String()
+	res, err := http.Get(url + "/ping")
+	Ok(t, err)
+	res.Body.Close()
+	shut := make(chan error)
+	go func() { shut <- s.Shutdown(context.Background()) }()
+	time.Sleep(50 * time.Millisecond)
+	res, err = http.Get(url + DefaultReadinessPath)
+	Ok(t, err)
+	res.Body.Close()
+	Equals(t, 503, res.StatusCode, "should fail readiness while draining")
+	res, err = http.Get(url + "/ping")
+	Ok(t, err)
+	res.Body.Close()
+	Equals(t, 204, res.StatusCode, "should still serve new requests")
+	Ok(t, <-shut)
+	Ok(t, <-served)
+}
+
+type unservedDefiner struct{}
+
+func (unservedDefiner) Domain() cqrs.Domain { return unservedDomain }
+
+type Archive struct {
+	cqrs.JsonSerialized
+	unservedDefiner
+}
+
+var (
+	unservedDomain = domains.NewDomain(unservedDefiner{}, "github.com/xzeus/cqrs/apiserver/test/unserved/v1", &ticket{})
+	C_Archive      = unservedDomain.DefCommand(1, 1, &Archive{}) // Never handled
+)
+
+func Test_Should_only_require_handlers_for_served_domains(t *testing.T) {
+	serve := func(served ...cqrs.Domain) error {
+		s, err := NewServer(testProvider(testdeps.NewDependencies()))
+		Ok(t, err)
+		s.Config(RequireHandlers(served...))
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		Ok(t, err)
+		result := make(chan error)
+		go func() { result <- s.Serve(l) }()
+		select {
+		case err := <-result:
+			return err
+		case <-time.After(50 * time.Millisecond): // Serving
+		}
+		Ok(t, s.Shutdown(context.Background()))
+		return <-result
+	}
+	Ok(t, serve(ticketDomain))
+	err, ok := serve(unservedDomain).(*domains.RegistrationError)
+	Assert(t, ok, "should refuse to serve [ %s ] without it's handler", unservedDomain.Uri())
+	Equals(t, 1, len(err.Conflicts), "should only report the served domain %v", err.Conflicts)
+}
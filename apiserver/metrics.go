@@ -0,0 +1,26 @@
+package apiserver
+
+import (
+	"bytes"
+	"github.com/xzeus/cqrs/ioc"
+)
+
+const PrometheusContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Metrics exposes the request's metrics dependency in the prometheus text
+// format, the dependency must implement ioc.MetricsWriter
+func Metrics(path string, mods ...func(RouteNodeHandler)) func(RouteNode) {
+	return View(path, func(req Request, resp Response) {
+		w, ok := req.Deps().Metrics().(ioc.MetricsWriter)
+		if !ok {
+			resp.Error("metrics not exposed", 50010, 501, nil)
+			return
+		}
+		buffer := new(bytes.Buffer)
+		if err := w.WritePrometheus(buffer); err != nil {
+			resp.Fail(err)
+			return
+		}
+		resp.Binary(buffer.Bytes(), PrometheusContentType, 200)
+	}, mods...)
+}
@@ -0,0 +1,41 @@
+package apiserver_test
+
+import (
+	. "github.com/xzeus/cqrs/apiserver"
+	"github.com/xzeus/cqrs/ioc"
+	. "github.com/xzeus/cqrs/testing"
+	"github.com/xzeus/cqrs/testing/testdeps"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func testProvider(deps ioc.Dependencies) ProviderFunc {
+	return func(r *http.Request) func() ioc.Dependencies {
+		return func() ioc.Dependencies {
+			return deps
+		}
+	}
+}
+
+func Test_Should_expose_request_metrics(t *testing.T) {
+	s, err := NewServer(testProvider(testdeps.NewDependencies()))
+	Ok(t, err)
+	s.Define(
+		View("foo", func(req Request, resp Response) { resp.Empty(204) }),
+		Metrics("metrics"),
+	)
+	test_server := httptest.NewServer(s.BuildRouter())
+	defer test_server.Close()
+	_, err = http.Get(test_server.URL + "/foo")
+	Ok(t, err)
+	res, err := http.Get(test_server.URL + "/metrics")
+	Ok(t, err)
+	body, err := ioutil.ReadAll(res.Body)
+	Ok(t, err)
+	res.Body.Close()
+	Equals(t, PrometheusContentType, res.Header.Get("Content-Type"), "should use the prometheus content type")
+	Assert(t, strings.Contains(string(body), `http_requests_total{method="GET",path="/foo",status="204"} 1`), "should count the request [ %s ]", body)
+}
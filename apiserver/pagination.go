@@ -0,0 +1,126 @@
+package apiserver
+
+import (
+	"fmt"
+	"github.com/xzeus/cqrs/ioc"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+const (
+	DefaultPageSize = 20
+	MaxPageSize     = 100
+	Link            = "Link"
+)
+
+// Cursors are prefixed so that offsets and data store cursors can't be
+// mistaken for one another
+const (
+	offset_cursor = "o."
+	store_cursor  = "c."
+)
+
+// Page is the envelope of a paginated view, Next and Prev are cursors for
+// the cursor query parameter and are left out at either end
+type Page struct {
+	Items interface{} `json:"items"`
+	Count int         `json:"count"`
+	Next  string      `json:"next,omitempty"`
+	Prev  string      `json:"prev,omitempty"`
+}
+
+// PageQueryFunc builds the query for a page and the slice pointer it's
+// results are loaded into, limit and the starting point are set by ByCursor
+type PageQueryFunc func(req Request) (*ioc.DataStoreQuery, interface{}, error)
+
+func offsetCursor(offset int) string {
+	return offset_cursor + strconv.Itoa(offset)
+}
+
+// pageLink is the request's url with the cursor replaced
+func pageLink(req Request, cursor string, rel string) string {
+	u := *req.Request().URL
+	q := u.Query()
+	q.Set("cursor", cursor)
+	u.RawQuery = q.Encode()
+	return fmt.Sprintf(`<%s>; rel="%s"`, u.RequestURI(), rel)
+}
+
+// ByCursor responds with a Page of the query's results, resuming from the
+// cursor query parameter or the count and offset parsed by ExtractPage,
+// stores implementing ioc.DataStoreCursorReader page by their own cursors
+// while others page by offset
+func ByCursor(f PageQueryFunc) ApiFunc {
+	return func(req Request, resp Response) {
+		count, offset := ExtractPage(req.Request().URL, DefaultPageSize, 0)
+		if count > MaxPageSize {
+			count = MaxPageSize
+		}
+		query, data, err := f(req)
+		if err != nil {
+			resp.View(nil, err)
+			return
+		}
+		store := req.Deps().DataStore()
+		cursorer, can_cursor := store.(ioc.DataStoreCursorReader)
+		cursor := req.Request().URL.Query().Get("cursor")
+		switch {
+		case cursor == "":
+		case strings.HasPrefix(cursor, offset_cursor):
+			if offset, err = strconv.Atoi(cursor[len(offset_cursor):]); err != nil || offset < 0 {
+				resp.Error("malformed cursor", 40140, 400, err)
+				return
+			}
+		case strings.HasPrefix(cursor, store_cursor):
+			if !can_cursor { // Would silently restart from the first page
+				resp.Error("cursor not supported", 40190, 400, nil)
+				return
+			}
+			query.StartAt(cursor[len(store_cursor):])
+		default:
+			resp.Error("unknown cursor", 40200, 400, nil)
+			return
+		}
+		query.Limit = count
+		page := &Page{Items: data}
+		if can_cursor && (query.Cursor != "" || offset == 0) { // Offsets still skip
+			next, err := cursorer.ExecQueryCursor(query, data)
+			if err != nil {
+				resp.View(nil, err)
+				return
+			}
+			if next != "" {
+				page.Next = store_cursor + next
+			}
+		} else {
+			query.Offset = offset
+			if err := store.ExecQuery(query, data); err != nil {
+				resp.View(nil, err)
+				return
+			}
+			if reflect.Indirect(reflect.ValueOf(data)).Len() >= count {
+				page.Next = offsetCursor(offset + count)
+			}
+			if offset > 0 {
+				prev := offset - count
+				if prev < 0 {
+					prev = 0
+				}
+				page.Prev = offsetCursor(prev)
+			}
+		}
+		page.Count = reflect.Indirect(reflect.ValueOf(data)).Len()
+		links := []string{}
+		if page.Next != "" {
+			links = append(links, pageLink(req, page.Next, "next"))
+		}
+		if page.Prev != "" {
+			links = append(links, pageLink(req, page.Prev, "prev"))
+		}
+		if len(links) > 0 {
+			resp.Recorder().Header().Set(Link, strings.Join(links, ", "))
+		}
+		resp.Json(page, 200)
+	}
+}
@@ -0,0 +1,120 @@
+package apiserver_test
+
+import (
+	"encoding/json"
+	. "github.com/xzeus/cqrs/apiserver"
+	"github.com/xzeus/cqrs/ioc"
+	. "github.com/xzeus/cqrs/testing"
+	"github.com/xzeus/cqrs/testing/testdeps"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// numberStore holds 0 through 24 under any kind
+type numberStore struct {
+	ioc.DataStoreReaderWriter
+}
+
+func (numberStore) ExecQuery(query ioc.DataStoreQuerier, data interface{}) error {
+	q := query.ToQuery()
+	r := data.(*[]int)
+	for i := q.Offset; i < 25 && len(*r) < q.Limit; i++ {
+		*r = append(*r, i)
+	}
+	return nil
+}
+
+type numberDependencies struct {
+	*testdeps.Dependencies
+}
+
+func (numberDependencies) DataStore() ioc.DataStoreReaderWriter { return numberStore{} }
+
+func getPage(t *testing.T, s Server, path string, v interface{}) *http.Response {
+	test_server := httptest.NewServer(s.BuildRouter())
+	defer test_server.Close()
+	res, err := http.Get(test_server.URL + path)
+	Ok(t, err)
+	defer res.Body.Close()
+	Ok(t, json.NewDecoder(res.Body).Decode(v))
+	return res
+}
+
+func Test_Should_pass_parsed_page_to_view(t *testing.T) {
+	s, err := NewServer(testProvider(testdeps.NewDependencies()))
+	Ok(t, err)
+	s.Define(
+		View("numbers", ByCount(func(deps ioc.Dependencies, count, offset int64) (interface{}, error) {
+			return []int64{count, offset}, nil
+		})),
+		View("pages", ByPage(func(deps ioc.Dependencies, page, offset int64) (interface{}, error) {
+			return []int64{page, offset}, nil
+		})),
+	)
+	result := []int64{}
+	getPage(t, s, "/numbers?p=5_10", &result)
+	Equals(t, []int64{5, 10}, result, "should use the parsed count and offset")
+	result = []int64{}
+	getPage(t, s, "/pages?p=5_10", &result)
+	Equals(t, []int64{2, 10}, result, "should use the page number and offset")
+}
+
+func Test_Should_page_through_query_by_cursor(t *testing.T) {
+	s, err := NewServer(testProvider(numberDependencies{testdeps.NewDependencies()}))
+	Ok(t, err)
+	s.Define(View("numbers", ByCursor(func(req Request) (*ioc.DataStoreQuery, interface{}, error) {
+		return ioc.NewDataStoreQuery("number").ToQuery(), &[]int{}, nil
+	})))
+	type numberPage struct {
+		Items []int  `json:"items"`
+		Count int    `json:"count"`
+		Next  string `json:"next"`
+		Prev  string `json:"prev"`
+	}
+	page := numberPage{}
+	res := getPage(t, s, "/numbers?count=10", &page)
+	Equals(t, 10, page.Count, "should take the count")
+	Equals(t, "", page.Prev, "should not have a previous page")
+	Assert(t, res.Header.Get("Link") != "", "should link the next page")
+	next := page.Next
+	page = numberPage{}
+	getPage(t, s, "/numbers?count=10&cursor="+next, &page)
+	Equals(t, 10, page.Items[0], "should resume from the cursor")
+	next = page.Next
+	page = numberPage{}
+	getPage(t, s, "/numbers?count=10&cursor="+next, &page)
+	Equals(t, 5, page.Count, "should return the last partial page")
+	Equals(t, "", page.Next, "should not have a next page")
+	Equals(t, "o.10", page.Prev, "should link the previous page")
+}
+
+func Test_Should_reject_store_cursors_the_store_cannot_resume(t *testing.T) {
+	s, err := NewServer(testProvider(numberDependencies{testdeps.NewDependencies()}))
+	Ok(t, err)
+	s.Define(View("numbers", ByCursor(func(req Request) (*ioc.DataStoreQuery, interface{}, error) {
+		return ioc.NewDataStoreQuery("number").ToQuery(), &[]int{}, nil
+	})))
+	result := struct {
+		Code int `json:"code"`
+	}{}
+	res := getPage(t, s, "/numbers?cursor=c.abc", &result)
+	Equals(t, 400, res.StatusCode, "should reject the cursor")
+	Equals(t, 40190, result.Code, "should report an unsupported cursor")
+}
+
+func Test_Should_distinguish_malformed_and_unknown_cursors(t *testing.T) {
+	s, err := NewServer(testProvider(testdeps.NewDependencies()))
+	Ok(t, err)
+	s.Define(View("numbers", ByCursor(func(req Request) (*ioc.DataStoreQuery, interface{}, error) {
+		return ioc.NewDataStoreQuery("number").ToQuery(), &[]int{}, nil
+	})))
+	for cursor, code := range map[string]int{"o.x": 40140, "o.-1": 40140, "x.1": 40200} {
+		result := struct {
+			Code int `json:"code"`
+		}{}
+		res := getPage(t, s, "/numbers?cursor="+cursor, &result)
+		Equals(t, 400, res.StatusCode, "should reject [ %s ]", cursor)
+		Equals(t, code, result.Code, "should report [ %s ] with it's own code", cursor)
+	}
+}
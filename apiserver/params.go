@@ -0,0 +1,121 @@
+package apiserver
+
+import (
+	"errors"
+	"github.com/gorilla/mux"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrMissingParam   = errors.New("path parameter not found")
+	ErrMalformedParam = errors.New("malformed path parameter")
+)
+
+const uuid_pattern = "[a-fA-F0-9]{8}-[a-fA-F0-9]{4}-[a-fA-F0-9]{4}-[a-fA-F0-9]{4}-[a-fA-F0-9]{12}"
+
+var uuid_regexp = regexp.MustCompile("^" + uuid_pattern + "$")
+
+// HexVar is a route segment capturing a hex id as the named parameter
+func HexVar(name string) string { return "{" + name + ":-?[a-fA-F0-9]+}" }
+
+// DecVar is a route segment capturing a decimal id as the named parameter
+func DecVar(name string) string { return "{" + name + ":-?[0-9]+}" }
+
+// StringVar is a route segment capturing any value as the named parameter
+func StringVar(name string) string { return "{" + name + "}" }
+
+// UUIDVar is a route segment capturing a uuid as the named parameter
+func UUIDVar(name string) string { return "{" + name + ":" + uuid_pattern + "}" }
+
+func (r *request) PathParams() map[string]string {
+	return mux.Vars(r.Request())
+}
+
+func (r *request) StringParam(name string) (string, error) {
+	v, found := r.PathParams()[name]
+	if !found || v == "" {
+		return "", ErrMissingParam
+	}
+	return v, nil
+}
+
+// HexParam reads an id as written by Hex64, a leading minus is also accepted
+func (r *request) HexParam(name string) (int64, error) {
+	v, err := r.StringParam(name)
+	if err != nil {
+		return 0, err
+	}
+	if strings.HasPrefix(v, "-") {
+		id, err := strconv.ParseInt(v, 16, 64)
+		if err != nil {
+			return 0, ErrMalformedParam
+		}
+		return id, nil
+	}
+	id, err := strconv.ParseUint(v, 16, 64)
+	if err != nil {
+		return 0, ErrMalformedParam
+	}
+	return int64(id), nil
+}
+
+func (r *request) DecParam(name string) (int64, error) {
+	v, err := r.StringParam(name)
+	if err != nil {
+		return 0, err
+	}
+	id, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return 0, ErrMalformedParam
+	}
+	return id, nil
+}
+
+// UUIDParam reads a uuid in it's canonical lower case form
+func (r *request) UUIDParam(name string) (string, error) {
+	v, err := r.StringParam(name)
+	if err != nil {
+		return "", err
+	}
+	if !uuid_regexp.MatchString(v) {
+		return "", ErrMalformedParam
+	}
+	return strings.ToLower(v), nil
+}
+
+// ParamError responds 404 for a missing parameter and 400 for a malformed one
+func ParamError(resp Response, name string, err error) {
+	if err == ErrMalformedParam {
+		resp.Error("malformed [ "+name+" ] in request", 40130, 400, err)
+		return
+	}
+	resp.Error("[ "+name+" ] not found in request", 40030, 404, err)
+}
+
+// ByIdFromParam loads the view by the named hex id parameter
+func ByIdFromParam(name string, f ViewByIdLoaderFunc) ApiFunc {
+	return func(req Request, resp Response) {
+		id, err := req.HexParam(name)
+		if err != nil {
+			ParamError(resp, name, err)
+			return
+		}
+		v, err := f(req.Deps(), id)
+		resp.View(v, err)
+	}
+}
+
+// ByStringFromParam loads the view by the named parameter
+func ByStringFromParam(name string, f ViewByStringFunc) ApiFunc {
+	return func(req Request, resp Response) {
+		s, err := req.StringParam(name)
+		if err != nil {
+			ParamError(resp, name, err)
+			return
+		}
+		v, err := f(req.Deps(), s)
+		resp.View(v, err)
+	}
+}
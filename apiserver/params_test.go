@@ -0,0 +1,58 @@
+package apiserver_test
+
+import (
+	"fmt"
+	. "github.com/xzeus/cqrs/apiserver"
+	. "github.com/xzeus/cqrs/testing"
+	"github.com/xzeus/cqrs/testing/testdeps"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func getParams(t *testing.T, route string, path string, h ApiFunc) (int, string) {
+	s, err := NewServer(testProvider(testdeps.NewDependencies()))
+	Ok(t, err)
+	s.Define(View(route, h))
+	test_server := httptest.NewServer(s.BuildRouter())
+	defer test_server.Close()
+	res, err := http.Get(test_server.URL + path)
+	Ok(t, err)
+	defer res.Body.Close()
+	body, _ := ioutil.ReadAll(res.Body)
+	return res.StatusCode, string(body)
+}
+
+func Test_Should_read_typed_path_params_by_name(t *testing.T) {
+	status, body := getParams(t, "boards/"+HexVar("board")+"/tickets/"+DecVar("n")+"/"+UUIDVar("ref"), "/boards/ff/tickets/12/0F8FAD5B-D9CB-469F-A165-70867728950E", func(req Request, resp Response) {
+		board, err := req.HexParam("board")
+		Ok(t, err)
+		n, err := req.DecParam("n")
+		Ok(t, err)
+		ref, err := req.UUIDParam("ref")
+		Ok(t, err)
+		resp.Text(fmt.Sprintf("%d %d %s", board, n, ref), 200)
+	})
+	Equals(t, 200, status, "should route the params")
+	Equals(t, "255 12 0f8fad5b-d9cb-469f-a165-70867728950e", body, "should parse each param")
+
+	status, _ = getParams(t, "ids/"+URL_DEC_ID, "/ids/123", func(req Request, resp Response) {
+		id, err := req.DecParam("id")
+		Ok(t, err)
+		Equals(t, int64(123), id, "should capture the whole decimal id")
+		resp.Empty(204)
+	})
+	Equals(t, 204, status, "should route decimal ids")
+}
+
+func Test_Should_reject_malformed_params(t *testing.T) {
+	status, _ := getParams(t, "tickets/"+StringVar("id"), "/tickets/nothex", ByIdFromParam("id", nil))
+	Equals(t, 400, status, "should reject the malformed id")
+	status, _ = getParams(t, "tickets/"+StringVar("id"), "/tickets/a", ByIdFromParam("missing", nil))
+	Equals(t, 404, status, "should not find an undeclared param")
+	status, _ = getParams(t, "tickets/"+StringVar("id"), "/tickets/nothex", ByIdFromPath("id", nil))
+	Equals(t, 400, status, "should reject the malformed id by path")
+	status, _ = getParams(t, "blobs/"+StringVar("id"), "/blobs/nothex", BlobDownload("blobs", "id", "blob.bin", "application/octet-stream"))
+	Equals(t, 400, status, "should reject the malformed blob id")
+}
@@ -0,0 +1,168 @@
+package apiserver
+
+import (
+	"github.com/xzeus/cqrs/ioc"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	RetryAfter          = "Retry-After"
+	XRateLimitLimit     = "X-RateLimit-Limit"
+	XRateLimitRemaining = "X-RateLimit-Remaining"
+)
+
+// rate_prefix namespaces the buckets kept in the CacheStore
+const rate_prefix = "apiserver:rate:"
+
+// RateKey identifies who a request is limited as, returning false when the
+// request has nothing to key by
+type RateKey func(Request) (string, bool)
+
+// BySession keys by the session id of a token verified by LoadTokens so that
+// refreshed tokens share a bucket
+func BySession(key string) RateKey {
+	return func(req Request) (string, bool) {
+		claims, err := req.GetClaims(key)
+		if err != nil || claims.Id == "" {
+			return "", false
+		}
+		return key + ":" + claims.Id, true
+	}
+}
+
+// ByClientIP keys by the connection's address, behind proxies which each
+// append to X-Forwarded-For the count of them picks the address the nearest
+// proxy outside of them saw as the client, earlier entries are set by the
+// client and are never used
+func ByClientIP(proxies int) RateKey {
+	return func(req Request) (string, bool) {
+		r := req.Request()
+		if proxies > 0 {
+			var hops []string
+			for _, v := range r.Header.Values("X-Forwarded-For") {
+				hops = append(hops, strings.Split(v, ",")...)
+			}
+			if len(hops) < proxies { // Not sent through every proxy
+				return "", false
+			}
+			host := strings.TrimSpace(hops[len(hops)-proxies])
+			return "ip:" + host, host != ""
+		}
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			host = r.RemoteAddr
+		}
+		return "ip:" + host, host != ""
+	}
+}
+
+// ByAPIKey keys by the value of the header i.e. X-Api-Key
+func ByAPIKey(header string) RateKey {
+	return func(req Request) (string, bool) {
+		v := req.Request().Header.Get(header)
+		return "api:" + v, v != ""
+	}
+}
+
+// RateLimit is a token bucket refilled at Rate tokens per second up to Burst
+type RateLimit struct {
+	// Name separates the buckets of routes with different limits
+	Name  string
+	Rate  float64
+	Burst int
+	// Keys are tried in order and the first found is used, requests without
+	// any key aren't limited
+	Keys []RateKey
+}
+
+// RateBucket is the state kept in the CacheStore for each key
+type RateBucket struct {
+	Tokens  float64 `json:"tokens"`
+	Updated int64   `json:"updated"` // Unix nano
+}
+
+// Take refills the bucket to now and takes a token if one is available,
+// otherwise returning how long until one will be
+func (l RateLimit) Take(b *RateBucket, now int64) (bool, time.Duration) {
+	if b.Updated == 0 {
+		b.Tokens = float64(l.Burst)
+	} else if elapsed := now - b.Updated; elapsed > 0 {
+		b.Tokens = math.Min(float64(l.Burst), b.Tokens+l.Rate*float64(elapsed)/float64(time.Second))
+	}
+	b.Updated = now
+	if b.Tokens >= 1 {
+		b.Tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.Tokens) / l.Rate * float64(time.Second))
+}
+
+// refill is how long an empty bucket takes to fill, after which it's the
+// same as a missing one
+func (l RateLimit) refill() time.Duration {
+	return time.Duration(math.Ceil(float64(l.Burst) / l.Rate * float64(time.Second)))
+}
+
+func setBucket(store ioc.CacheStoreReaderWriter, key string, b *RateBucket, ttl time.Duration) error {
+	if e, ok := store.(ioc.CacheStoreExpirer); ok {
+		return e.SetExpiring(key, b, ttl)
+	}
+	return store.Set(key, b)
+}
+
+// NewRateLimit responds 429 with a Retry-After once a key's bucket is empty,
+// buckets are kept in the CacheStore so instances sharing one share limits
+// though concurrent requests may each take the same token, a store which
+// isn't an ioc.CacheStoreExpirer keeps a bucket for every key ever seen
+func NewRateLimit(limit RateLimit) MiddlewareFunc {
+	if len(limit.Keys) == 0 {
+		limit.Keys = []RateKey{ByClientIP(0)}
+	}
+	return func(h ApiFunc) ApiFunc {
+		return func(req Request, resp Response) {
+			if req.Request().Method == OPTIONS || limit.Rate <= 0 {
+				h(req, resp)
+				return
+			}
+			key, found := "", false
+			for _, k := range limit.Keys {
+				if key, found = k(req); found {
+					break
+				}
+			}
+			if !found {
+				h(req, resp)
+				return
+			}
+			deps := req.Deps()
+			cache_key := rate_prefix + limit.Name + ":" + key
+			bucket := &RateBucket{}
+			if err := deps.CacheStore().Get(cache_key, bucket); err != nil && err != ioc.ErrCacheMiss {
+				deps.Logger().With(ioc.Fields{ioc.FieldError: err}).Warnf("rate limit unavailable")
+				h(req, resp) // Fail open rather than blocking every request
+				return
+			}
+			allowed, wait := limit.Take(bucket, deps.Time().Now())
+			if err := setBucket(deps.CacheStore(), cache_key, bucket, limit.refill()); err != nil {
+				deps.Logger().With(ioc.Fields{ioc.FieldError: err}).Warnf("rate limit unavailable")
+			}
+			if !allowed {
+				resp.Error("rate limit exceeded", 42900, 429, nil)
+				resp.Recorder().Header().Set(RetryAfter, strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+				resp.Recorder().Header().Set(XRateLimitLimit, strconv.Itoa(limit.Burst))
+				resp.Recorder().Header().Set(XRateLimitRemaining, "0")
+				return
+			}
+			resp.OnHeaders(func(header http.Header) {
+				header.Set(XRateLimitLimit, strconv.Itoa(limit.Burst))
+				header.Set(XRateLimitRemaining, strconv.Itoa(int(bucket.Tokens)))
+			})
+			h(req, resp)
+		}
+	}
+}
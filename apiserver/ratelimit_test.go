@@ -0,0 +1,80 @@
+package apiserver_test
+
+import (
+	. "github.com/xzeus/cqrs/apiserver"
+	. "github.com/xzeus/cqrs/testing"
+	"github.com/xzeus/cqrs/testing/testdeps"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func Test_Should_limit_each_api_key_to_its_own_bucket(t *testing.T) {
+	deps := testdeps.NewDependencies()
+	now := time.Unix(1000, 0).UnixNano()
+	deps.Mock_Time.Mock_Now = func() int64 { return now }
+	s, err := NewServer(testProvider(deps))
+	Ok(t, err)
+	limit := NewRateLimit(RateLimit{Name: "ping", Rate: 0.5, Burst: 2, Keys: []RateKey{ByAPIKey("X-Api-Key")}})
+	s.Define(View("ping", limit(func(req Request, resp Response) { resp.Empty(204) })))
+	test_server := httptest.NewServer(s.BuildRouter())
+	defer test_server.Close()
+	get := func(api_key string) *http.Response {
+		r, _ := http.NewRequest("GET", test_server.URL+"/ping", nil)
+		r.Header.Set("X-Api-Key", api_key)
+		res, err := http.DefaultClient.Do(r)
+		Ok(t, err)
+		res.Body.Close()
+		return res
+	}
+
+	Equals(t, 204, get("a").StatusCode, "should allow the first request")
+	Equals(t, 204, get("a").StatusCode, "should allow the burst")
+	res := get("a")
+	Equals(t, 429, res.StatusCode, "should limit once the bucket is empty")
+	Equals(t, "2", res.Header.Get("Retry-After"), "should wait for the next token")
+	Equals(t, 204, get("b").StatusCode, "should not limit other keys")
+	now += int64(2 * time.Second)
+	Equals(t, 204, get("a").StatusCode, "should allow once refilled")
+}
+
+func Test_Should_key_forwarded_clients_by_the_trusted_hop(t *testing.T) {
+	deps := testdeps.NewDependencies()
+	s, err := NewServer(testProvider(deps))
+	Ok(t, err)
+	limit := NewRateLimit(RateLimit{Name: "ip", Rate: 1, Burst: 1, Keys: []RateKey{ByClientIP(1)}})
+	s.Define(View("ping", limit(func(req Request, resp Response) { resp.Empty(204) })))
+	test_server := httptest.NewServer(s.BuildRouter())
+	defer test_server.Close()
+	get := func(forwarded string) int {
+		r, _ := http.NewRequest("GET", test_server.URL+"/ping", nil)
+		r.Header.Set("X-Forwarded-For", forwarded)
+		res, err := http.DefaultClient.Do(r)
+		Ok(t, err)
+		res.Body.Close()
+		return res.StatusCode
+	}
+
+	Equals(t, 204, get("10.0.0.1, 203.0.113.7"), "should allow the first request")
+	Equals(t, 429, get("10.0.0.2, 203.0.113.7"), "should ignore the spoofed client entry")
+	Equals(t, 204, get("198.51.100.4"), "should not limit other clients")
+	Equals(t, time.Second, deps.Mock_CacheStore.TTL("apiserver:rate:ip:ip:203.0.113.7"), "should expire the bucket once refilled")
+}
+
+func Test_Should_send_rate_limit_headers_with_a_stream(t *testing.T) {
+	s, err := NewServer(testProvider(testdeps.NewDependencies()))
+	Ok(t, err)
+	limit := NewRateLimit(RateLimit{Name: "stream", Rate: 1, Burst: 5})
+	s.Define(View("stream", limit(func(req Request, resp Response) {
+		w, err := resp.NDJson(200)
+		Ok(t, err)
+		w.Write(1)
+	})))
+	test_server := httptest.NewServer(s.BuildRouter())
+	defer test_server.Close()
+	res, err := http.Get(test_server.URL + "/stream")
+	Ok(t, err)
+	res.Body.Close()
+	Equals(t, "4", res.Header.Get("X-RateLimit-Remaining"), "should send the remaining tokens")
+}
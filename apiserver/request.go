@@ -18,14 +18,30 @@ type StringExtractor func() (string, bool)
 type BinaryExtractor func() ([]byte, bool)
 
 type Request interface {
+	// Deps are the provider's dependencies bound to the request's context,
+	// they're wrapped unless they implement ioc.DependenciesContexter so
+	// assert their type on ioc.Unwrap(req.Deps())
 	Deps() ioc.Dependencies
 	Request() *http.Request
+	// Trace is the context of the span covering this request
+	Trace() ioc.SpanContext
 	GetToken(string) (*j.TokenDef, error)
+	// GetClaims returns the registered claims of a verified token
+	GetClaims(string) (*Claims, error)
+	PutToken(string, *j.TokenDef)
+	PutClaims(string, *Claims)
 	BaseUri() string
 	Segment() (string, error)
 	ExtractInt32ElementId() (int32, bool)
-	ExtractInt64ElementId() (int64, bool)
+	// ExtractInt64ElementId reads the named hex id parameter as HexParam does
+	ExtractInt64ElementId(string) (int64, error)
 	ExtractStringElementId() (string, bool)
+	// PathParams are the variables captured by the route i.e. HexVar("id")
+	PathParams() map[string]string
+	StringParam(string) (string, error)
+	HexParam(string) (int64, error)
+	DecParam(string) (int64, error)
+	UUIDParam(string) (string, error)
 	Int32ExtractorByQuery(string) Int32Extractor
 	Int64ExtractorByQuery(string) Int64Extractor
 	StringExtractorByQuery(string) StringExtractor
@@ -37,6 +53,8 @@ type request struct {
 	deps    ioc.Dependencies
 	request *http.Request
 	tokens  map[string]*j.TokenDef // Only populated with verified tokens
+	claims  map[string]*Claims
+	trace   ioc.SpanContext
 }
 
 func NewRequest(deps ioc.Dependencies, r *http.Request) Request {
@@ -44,6 +62,7 @@ func NewRequest(deps ioc.Dependencies, r *http.Request) Request {
 		deps:    deps,
 		request: r,
 		tokens:  make(map[string]*j.TokenDef),
+		claims:  make(map[string]*Claims),
 	}
 }
 
@@ -55,6 +74,10 @@ func (r *request) Request() *http.Request {
 	return r.request
 }
 
+func (r *request) Trace() ioc.SpanContext {
+	return r.trace
+}
+
 func (r *request) PutToken(key string, t *j.TokenDef) {
 	r.tokens[key] = t
 }
@@ -66,6 +89,17 @@ func (r *request) GetToken(key string) (*j.TokenDef, error) {
 	return nil, ErrInvalidToken
 }
 
+func (r *request) PutClaims(key string, c *Claims) {
+	r.claims[key] = c
+}
+
+func (r *request) GetClaims(key string) (*Claims, error) {
+	if c, ok := r.claims[key]; ok {
+		return c, nil
+	}
+	return nil, ErrInvalidToken
+}
+
 func (r *request) BaseUri() (result string) {
 	scheme := "http"
 	if r.Request().TLS != nil {
@@ -86,10 +120,8 @@ func (r *request) ExtractInt32ElementId() (int32, bool) {
 	return int32(id), err == nil
 }
 
-func (r *request) ExtractInt64ElementId() (int64, bool) {
-	var str, _ = r.Segment()
-	id, err := Int64(str)
-	return id, err == nil
+func (r *request) ExtractInt64ElementId(name string) (int64, error) {
+	return r.HexParam(name)
 }
 
 func (r *request) ExtractStringElementId() (string, bool) {
@@ -2,12 +2,25 @@ package apiserver
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"github.com/xzeus/cqrs"
+	"github.com/xzeus/cqrs/exception"
 	"github.com/xzeus/cqrs/ioc"
 	"io"
-	"log"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"runtime/debug"
+)
+
+// Codes sent for exception events by the kind of failure
+const (
+	ErrorCodeRejected  = 40150
+	ErrorCodeForbidden = 40320
+	ErrorCodeNotFound  = 40410
+	ErrorCodeConflict  = 40910
+	ErrorCodeInternal  = 50020
 )
 
 type Response interface {
@@ -16,6 +29,10 @@ type Response interface {
 	// Recorder returns the buffered response writer
 	Recorder() *httptest.ResponseRecorder
 	Header() http.Header
+	// OnHeaders calls set with the headers as they're sent to the client,
+	// whether by Flush or when a stream starts, so middleware wrapping a
+	// handler can add headers that survive a Reset and reach a stream
+	OnHeaders(set func(http.Header))
 	// ResponseWriter  provides direct access to the underlying http.ResponseWriter
 	ResponseWriter() http.ResponseWriter
 	Empty(status int) error
@@ -29,9 +46,27 @@ type Response interface {
 	// and defaults status to 404 if not provided, only reads first value if many
 	// are provided
 	Error(message string, code int, status int, err error) error
+	// Invalid writes the field level validation errors with status 400
+	Invalid(errs cqrs.ValidationErrors) error
+	// HandlerError writes the status matching the kind of a cqrs.HandlerError
+	HandlerError(err error) error
+	// Exception writes the status matching the code of an exception event
+	Exception(e exception.Event) error
 	//
 	Fail(err error) error
 	Reset() error
+	// Stream writes the headers and status and returns a writer straight to
+	// the client, after which the response can't be reset
+	Stream(content_type string, status int) (*StreamWriter, error)
+	// JsonArray streams a json array one element at a time
+	JsonArray(status int) (*JsonArrayWriter, error)
+	// NDJson streams newline delimited json
+	NDJson(status int) (*NDJsonWriter, error)
+	// Download streams the content as an attachment
+	Download(file_name string, mime_type string, content io.Reader) error
+	// Detach hands the connection over to another protocol such as a
+	// websocket, nothing more is written by the response
+	Detach() (http.ResponseWriter, error)
 
 	Flush() error
 	Errorf(string, ...interface{})
@@ -46,8 +81,10 @@ type response struct {
 	recorder *httptest.ResponseRecorder
 	// Writer that sends result directly to the client
 	writer http.ResponseWriter
-	// Provides a function to log an error message
-	errorf func(string, ...interface{})
+	// Logs errors, the request's logger once served
+	log ioc.Logger
+	// Set headers as they're sent
+	on_headers []func(http.Header)
 }
 
 func NewResponse(w http.ResponseWriter) Response {
@@ -56,7 +93,7 @@ func NewResponse(w http.ResponseWriter) Response {
 		errorUrl: `https://api.vizidrix.com/v1/errors/%d`,
 		recorder: httptest.NewRecorder(),
 		writer:   w,
-		errorf:   log.Printf,
+		log:      ioc.NewJsonLogger(os.Stderr, ioc.LevelError),
 	}
 }
 
@@ -73,7 +110,22 @@ func (r *response) Recorder() *httptest.ResponseRecorder {
 }
 
 func (r *response) Header() http.Header {
-	return r.Header()
+	return r.recorder.Header()
+}
+
+func (r *response) OnHeaders(set func(http.Header)) {
+	r.on_headers = append(r.on_headers, set)
+}
+
+// sendHeaders runs the OnHeaders hooks and copies the buffered headers to
+// the client's writer
+func (r *response) sendHeaders() {
+	for _, set := range r.on_headers {
+		set(r.recorder.Header())
+	}
+	for k, v := range r.recorder.Header() {
+		r.writer.Header()[k] = v
+	}
 }
 
 func (r *response) ResponseWriter() http.ResponseWriter {
@@ -154,6 +206,61 @@ func (r *response) Error(message string, code int, status int, err error) error
 	}, status)
 }
 
+func (r *response) Invalid(errs cqrs.ValidationErrors) error {
+	if r.flushed {
+		return ErrResponseFlushed
+	}
+	return r.Json(struct {
+		Status  int                   `json:"status"`
+		Message string                `json:"message"`
+		Code    int                   `json:"code"`
+		Url     string                `json:"url"`
+		Fields  cqrs.ValidationErrors `json:"fields"`
+	}{
+		Status:  400,
+		Message: "validation failed",
+		Code:    40050,
+		Url:     fmt.Sprintf(r.ErrorUrl(), 40050),
+		Fields:  errs,
+	}, 400)
+}
+
+func (r *response) HandlerError(err error) error {
+	switch {
+	case errors.Is(err, cqrs.ErrUnknownMessage):
+		return r.Error("unknown message type", 40060, 400, err)
+	case errors.Is(err, cqrs.ErrDecodeFailure):
+		return r.Error("unable to decode message", 40070, 400, err)
+	case errors.Is(err, cqrs.ErrConflict):
+		return r.Error("aggregate version conflict", 40090, 409, err)
+	case errors.Is(err, cqrs.ErrCancelled):
+		return r.Error("request cancelled", 40810, 408, err)
+	case errors.Is(err, cqrs.ErrStoreUnavailable):
+		return r.Error("store unavailable", 50030, 503, err)
+	}
+	return r.Fail(err)
+}
+
+func (r *response) Exception(e exception.Event) error {
+	if v, ok := e.(*exception.ValidationFailed); ok {
+		return r.Invalid(v.Fields)
+	}
+	d := e.GetDetails()
+	switch d.Code {
+	case ioc.CodeInvalid:
+		return r.Error(d.Message, ErrorCodeRejected, 400, nil)
+	case ioc.CodeForbidden:
+		return r.Error(d.Message, ErrorCodeForbidden, 403, nil)
+	case ioc.CodeNotFound:
+		return r.Error(d.Message, ErrorCodeNotFound, 404, nil)
+	case ioc.CodeConflict:
+		return r.Error(d.Message, ErrorCodeConflict, 409, nil)
+	}
+	// The detail of an internal failure stays in the log
+	r.log.With(ioc.Fields{ioc.FieldError: d.Message}).Errorf("command failed with code [ %d ]", d.Code)
+	return r.Error("Internal server error", ErrorCodeInternal, 500, nil)
+}
+
 func (r *response) Fail(err error) error {
 	r.Reset() // Clear any previous data
 	return r.Error("Internal server error", 50000, 500, err)
@@ -172,9 +279,7 @@ func (r *response) Flush() error {
 		return ErrResponseFlushed
 	}
 	r.flushed = true
-	for k, v := range r.recorder.Header() {
-		r.writer.Header()[k] = v
-	}
+	r.sendHeaders()
 	// Set custom headers
 	//r.writer.header().Set("blah", "blah")
 	r.writer.WriteHeader(r.recorder.Code)
@@ -184,6 +289,5 @@ func (r *response) Flush() error {
 }
 
 func (r *response) Errorf(message string, values ...interface{}) {
-	log.Printf("** apiresponse.Errorf:\n[\n%s\n%#v\n]\n", message, values)
-	r.errorf(message, values...)
+	r.log.With(ioc.Fields{ioc.FieldStack: string(debug.Stack())}).Errorf(message, values...)
 }
@@ -1,5 +1,10 @@
 package apiserver
 
+import (
+	"fmt"
+	"sort"
+)
+
 type DepthCount int
 
 func (depth DepthCount) Tab() string {
@@ -17,9 +22,12 @@ type RouteNode interface {
 	Depth() DepthCount
 	Path() string
 	SetMiddleware(string, MiddlewareFunc)
+	AddMiddleware(MiddlewareEntry)
+	RemoveMiddleware(string)
 	AppendNode(RouteNode)
 	AppendHandler(RouteNodeHandler)
 	Middleware() map[string]MiddlewareFunc
+	MiddlewareEntries() []MiddlewareEntry
 	RouteNodes() []RouteNode
 	RouteNodeHandlers() []RouteNodeHandler
 }
@@ -28,7 +36,7 @@ type RouteNodeDef struct {
 	parent      RouteNode
 	depth       DepthCount
 	path        string
-	middleware  map[string]MiddlewareFunc
+	middleware  []MiddlewareEntry
 	route_nodes []RouteNode
 	handlers    []RouteNodeHandler
 }
@@ -42,7 +50,7 @@ func NewRouteNode(parent RouteNode, path string) RouteNode {
 		parent:      parent,
 		depth:       depth,
 		path:        path,
-		middleware:  make(map[string]MiddlewareFunc),
+		middleware:  make([]MiddlewareEntry, 0),
 		route_nodes: make([]RouteNode, 0),
 		handlers:    make([]RouteNodeHandler, 0),
 	}
@@ -67,8 +75,27 @@ func (r *RouteNodeDef) Path() string {
 	return r.path
 }
 
+// SetMiddleware adds the middleware at the default priority
 func (r *RouteNodeDef) SetMiddleware(key string, handler MiddlewareFunc) {
-	r.middleware[key] = handler
+	r.AddMiddleware(MiddlewareEntry{Key: key, Handler: handler})
+}
+
+// AddMiddleware keeps the node's middleware in the order added, an entry
+// with a key already on the node replaces it in place
+func (r *RouteNodeDef) AddMiddleware(entry MiddlewareEntry) {
+	for i, m := range r.middleware {
+		if m.Key == entry.Key {
+			r.middleware[i] = entry
+			return
+		}
+	}
+	r.middleware = append(r.middleware, entry)
+}
+
+// RemoveMiddleware drops the middleware from this node and any inherited
+// from it's parents for the routes beneath it
+func (r *RouteNodeDef) RemoveMiddleware(key string) {
+	r.AddMiddleware(MiddlewareEntry{Key: key})
 }
 
 func (r *RouteNodeDef) AppendNode(route_node RouteNode) {
@@ -80,6 +107,16 @@ func (r *RouteNodeDef) AppendHandler(handler RouteNodeHandler) {
 }
 
 func (r *RouteNodeDef) Middleware() map[string]MiddlewareFunc {
+	result := make(map[string]MiddlewareFunc)
+	for _, m := range r.middleware {
+		if m.Handler != nil {
+			result[m.Key] = m.Handler
+		}
+	}
+	return result
+}
+
+func (r *RouteNodeDef) MiddlewareEntries() []MiddlewareEntry {
 	return r.middleware
 }
 
@@ -91,12 +128,126 @@ func (r *RouteNodeDef) RouteNodeHandlers() []RouteNodeHandler {
 	return r.handlers
 }
 
+// MiddlewareEntry is a keyed middleware, lower priorities run first and
+// equal priorities run in the order they were added from the root down,
+// Before and After then move it around the keys listed which wrap the same
+// routes
+type MiddlewareEntry struct {
+	Key      string
+	Priority int
+	Before   []string
+	After    []string
+	Handler  MiddlewareFunc // Nil removes the key
+}
+
 func Middleware(key string, handler MiddlewareFunc) RouteModifier {
 	return func(r RouteNode) {
 		r.SetMiddleware(key, handler)
 	}
 }
 
+func MiddlewarePriority(key string, priority int, handler MiddlewareFunc) RouteModifier {
+	return func(r RouteNode) {
+		r.AddMiddleware(MiddlewareEntry{Key: key, Priority: priority, Handler: handler})
+	}
+}
+
+// MiddlewareBefore runs the middleware outside of the other key
+func MiddlewareBefore(key string, other string, handler MiddlewareFunc) RouteModifier {
+	return func(r RouteNode) {
+		r.AddMiddleware(MiddlewareEntry{Key: key, Before: []string{other}, Handler: handler})
+	}
+}
+
+// MiddlewareAfter runs the middleware inside of the other key
+func MiddlewareAfter(key string, other string, handler MiddlewareFunc) RouteModifier {
+	return func(r RouteNode) {
+		r.AddMiddleware(MiddlewareEntry{Key: key, After: []string{other}, Handler: handler})
+	}
+}
+
+// WithoutMiddleware removes inherited middleware from a Sub node's routes
+func WithoutMiddleware(key string) RouteModifier {
+	return func(r RouteNode) {
+		r.RemoveMiddleware(key)
+	}
+}
+
+// EffectiveMiddleware resolves the middleware which wraps the node's routes,
+// outermost first, a node's entry overrides or removes the same key from
+// it's parents while keeping the parent's position
+func EffectiveMiddleware(node RouteNode) []MiddlewareEntry {
+	nodes := make([]RouteNode, 0, node.Depth())
+	for n := node; n != nil; n = n.Parent() {
+		nodes = append([]RouteNode{n}, nodes...)
+	}
+	chain := make([]MiddlewareEntry, 0)
+	for _, n := range nodes {
+		for _, entry := range n.MiddlewareEntries() {
+			found := false
+			for i, m := range chain {
+				if m.Key == entry.Key {
+					chain[i], found = entry, true
+				}
+			}
+			if !found {
+				chain = append(chain, entry)
+			}
+		}
+	}
+	result := make([]MiddlewareEntry, 0, len(chain))
+	for _, m := range chain {
+		if m.Handler != nil {
+			result = append(result, m)
+		}
+	}
+	sort.SliceStable(result, func(i, j int) bool { return result[i].Priority < result[j].Priority })
+	return constrain(result)
+}
+
+// constrain pulls the entries which must run first ahead of each entry in
+// turn, panicking if the Before and After keys contradict each other
+func constrain(entries []MiddlewareEntry) []MiddlewareEntry {
+	index := make(map[string]int)
+	for i, m := range entries {
+		index[m.Key] = i
+	}
+	preceding := make([][]int, len(entries)) // Entries which must run first
+	for i, m := range entries {
+		for _, k := range m.Before {
+			if j, found := index[k]; found {
+				preceding[j] = append(preceding[j], i)
+			}
+		}
+		for _, k := range m.After {
+			if j, found := index[k]; found {
+				preceding[i] = append(preceding[i], j)
+			}
+		}
+	}
+	result := make([]MiddlewareEntry, 0, len(entries))
+	state := make([]int, len(entries)) // 1 while placing, 2 once placed
+	var place func(i int)
+	place = func(i int) {
+		switch state[i] {
+		case 1:
+			panic(fmt.Sprintf("middleware order of [ %s ] is circular", entries[i].Key))
+		case 2:
+			return
+		}
+		state[i] = 1
+		for _, j := range preceding[i] { // Pulled forward ahead of it
+			place(j)
+		}
+		state[i] = 2
+		result = append(result, entries[i])
+	}
+	for i := range entries {
+		place(i)
+	}
+	return result
+}
+
 func Sub(path string, routes ...RouteModifier) RouteModifier {
 	return func(r RouteNode) {
 		route_node := NewRouteNode(r, path)
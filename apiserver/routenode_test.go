@@ -3,7 +3,10 @@ package apiserver_test
 import (
 	. "github.com/xzeus/cqrs/apiserver"
 	. "github.com/xzeus/cqrs/testing"
+	"github.com/xzeus/cqrs/testing/testdeps"
 	//mock "github.com/vizidrix/zeus/testing/mockprovider"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 )
 
@@ -84,3 +87,68 @@ func Test_Should_run_middleware_for_child_nodes(t *testing.T) {
 	//n2.Middleware()["m1"](nil)(nil, nil)
 	//Equals(t, 2, m1, "should not have incremented yet")
 }
+
+func tracing(calls *[]string, name string) MiddlewareFunc {
+	return func(h ApiFunc) ApiFunc {
+		return func(req Request, resp Response) {
+			*calls = append(*calls, name)
+			h(req, resp)
+		}
+	}
+}
+
+func Test_Should_run_middleware_in_order_from_the_root_down(t *testing.T) {
+	calls := []string{}
+	s, err := NewServer(testProvider(testdeps.NewDependencies()))
+	Ok(t, err)
+	s.Define(
+		Middleware("a", tracing(&calls, "a")),
+		Middleware("b", tracing(&calls, "b")),
+		Middleware("c", tracing(&calls, "c")),
+		Sub("v1",
+			Middleware("d", tracing(&calls, "d")),
+			MiddlewarePriority("first", -1, tracing(&calls, "first")),
+			Middleware("b", tracing(&calls, "b2")),
+			WithoutMiddleware("c"),
+			View("x", func(req Request, resp Response) { resp.Empty(204) }),
+		),
+	)
+	Equals(t, []string{"first", "a", "b", "d"}, s.Compose()["/v1/x"].Middleware, "should resolve the chain")
+	test_server := httptest.NewServer(s.BuildRouter())
+	defer test_server.Close()
+	res, err := http.Get(test_server.URL + "/v1/x")
+	Ok(t, err)
+	res.Body.Close()
+	Equals(t, []string{"first", "a", "b2", "d"}, calls, "should run outermost first with overrides in place")
+}
+
+func Test_Should_order_middleware_around_other_keys(t *testing.T) {
+	calls := []string{}
+	s, err := NewServer(testProvider(testdeps.NewDependencies()))
+	Ok(t, err)
+	s.Define(
+		Middleware("tokens", tracing(&calls, "tokens")),
+		Middleware("limit", tracing(&calls, "limit")),
+		Sub("v1",
+			MiddlewareBefore("cors", "tokens", tracing(&calls, "cors")),
+			MiddlewareAfter("audit", "limit", tracing(&calls, "audit")),
+			MiddlewarePriority("metrics", 1, tracing(&calls, "metrics")),
+			View("x", func(req Request, resp Response) { resp.Empty(204) }),
+		),
+	)
+	Equals(t, []string{"cors", "tokens", "limit", "audit", "metrics"}, s.Compose()["/v1/x"].Middleware, "should satisfy the constraints")
+}
+
+func Test_Should_panic_on_circular_middleware_order(t *testing.T) {
+	s, err := NewServer(testProvider(testdeps.NewDependencies()))
+	Ok(t, err)
+	s.Define(
+		MiddlewareBefore("a", "b", tracing(&[]string{}, "a")),
+		MiddlewareBefore("b", "a", tracing(&[]string{}, "b")),
+		View("x", func(req Request, resp Response) { resp.Empty(204) }),
+	)
+	defer func() {
+		Assert(t, recover() != nil, "should have panicked")
+	}()
+	s.Compose()
+}
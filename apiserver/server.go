@@ -1,16 +1,20 @@
 package apiserver
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"github.com/gorilla/mux"
+	"github.com/xzeus/cqrs"
 	"github.com/xzeus/cqrs/ioc"
 	//"log"
+	"net"
 	"net/http"
 	"net/url"
 	"runtime/debug"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -26,7 +30,7 @@ var (
 
 const (
 	URL_HEX_ID = "{id:-?[a-fA-F0-9]+}"
-	URL_DEC_ID = "{id:-?[0-9]}+"
+	URL_DEC_ID = "{id:-?[0-9]+}"
 	URL_EMPTY  = ""
 )
 
@@ -43,6 +47,13 @@ const (
 	CORS_DefaultMethods            = "GET, POST, OPTIONS"
 	CORS_AccessControlAllowHeaders = "Access-Control-Allow-Headers"
 	CORS_DefaultHeaders            = "Accept, Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, X-Session-Token"
+
+	CORS_Origin                        = "Origin"
+	CORS_AccessControlAllowCredentials = "Access-Control-Allow-Credentials"
+	CORS_AccessControlExposeHeaders    = "Access-Control-Expose-Headers"
+	CORS_AccessControlMaxAge           = "Access-Control-Max-Age"
+	CORS_AccessControlRequestMethod    = "Access-Control-Request-Method"
+	CORS_AccessControlRequestHeaders   = "Access-Control-Request-Headers"
 )
 
 type ProviderFunc func(*http.Request) func() ioc.Dependencies
@@ -54,6 +65,7 @@ type ServerModifier func(Server)
 
 type Handler struct {
 	Methods    []string
+	Middleware []string // Effective middleware keys, outermost first
 	HandleFunc http.HandlerFunc
 }
 
@@ -73,6 +85,15 @@ type Server interface {
 
 	Compose() map[string]Handler
 	BuildRouter() http.Handler
+
+	AddRunner(Runner)
+	AddCheck(string, HealthCheck)
+	SetHealthPaths(liveness, readiness string)
+	SetDrainDelay(time.Duration)
+	RequireHandlers(...cqrs.Domain)
+	ListenAndServe(addr string) error
+	Serve(net.Listener) error
+	Shutdown(context.Context) error
 }
 
 type ServerDef struct {
@@ -80,6 +101,21 @@ type ServerDef struct {
 	deps_provider ProviderFunc
 	running       bool
 	route_nodes   []RouteNode
+
+	lock           sync.Mutex
+	runners        []Runner
+	runners_wg     sync.WaitGroup
+	runner_errs    []error // Runners which stopped before shutdown
+	cancel_runners context.CancelFunc
+	checks         []healthCheck
+	liveness_path  string
+	readiness_path string
+	drain_delay    time.Duration // Between failing readiness and closing listeners
+	required       []cqrs.Domain // Validated for unhandled commands on Serve
+	http_server    *http.Server
+	stopping       chan struct{} // Closed once shutdown begins
+	stopped        chan struct{} // Closed once shutdown has drained
+	shutdown_err   error
 }
 
 func NewServer(p ProviderFunc) (Server, error) {
@@ -87,9 +123,12 @@ func NewServer(p ProviderFunc) (Server, error) {
 		return nil, ErrInvalidProvider
 	}
 	return &ServerDef{
-		RouteNode:     NewRouteNode(nil, ""),
-		deps_provider: p,
-		route_nodes:   make([]RouteNode, 0),
+		RouteNode:      NewRouteNode(nil, ""),
+		deps_provider:  p,
+		route_nodes:    make([]RouteNode, 0),
+		liveness_path:  DefaultLivenessPath,
+		readiness_path: DefaultReadinessPath,
+		stopping:       make(chan struct{}),
 	}, nil
 }
 
@@ -127,36 +166,23 @@ func makePath(path_stack []string, leaf string) string {
 	return path + "/" + leaf
 }
 
-func flattenMiddleware(middleware map[string]MiddlewareFunc) []MiddlewareFunc {
-	r := make([]MiddlewareFunc, 0, len(middleware))
-	for _, m := range middleware {
-		r = append(r, m)
-	}
-	return r
-}
-
-func flattenMiddlewareStack(middleware_stack [][]MiddlewareFunc) []MiddlewareFunc {
-	r := make([]MiddlewareFunc, 0)
-	for _, set := range middleware_stack {
-		r = append(r, set...)
-	}
-	return r
-}
-
 func (s *ServerDef) Compose() map[string]Handler {
 	var _compose func(RouteNode, Server)
 	r := make(map[string]Handler)
 	path_stack := []string{}
-	middleware_stack := make([][]MiddlewareFunc, 0)
 	_compose = func(node RouteNode, s Server) {
 		path_stack = append(path_stack, node.Path())
-		middleware_stack = append(middleware_stack, flattenMiddleware(node.Middleware()))
+		middleware := EffectiveMiddleware(node)
+		keys := make([]string, len(middleware))
+		for i, m := range middleware {
+			keys[i] = m.Key
+		}
 		for _, handler := range node.RouteNodeHandlers() {
 			h := handler
 			path := makePath(path_stack, h.Path())
-			middleware := flattenMiddlewareStack(middleware_stack)
 			r[path] = Handler{
 				Methods:    h.Methods(),
+				Middleware: keys,
 				HandleFunc: makeHandlerFunc(node, h, s, path, middleware),
 			}
 		}
@@ -164,7 +190,6 @@ func (s *ServerDef) Compose() map[string]Handler {
 			_compose(child_node, s)
 		}
 		path_stack = path_stack[0 : len(path_stack)-1]
-		middleware_stack = middleware_stack[0 : len(middleware_stack)-1]
 	}
 	_compose(s, s)
 	return r
@@ -176,40 +201,62 @@ func (s *ServerDef) BuildRouter() http.Handler {
 	for p, h := range c {
 		r.HandleFunc(p, h.HandleFunc).Methods(h.Methods...)
 	}
+	if s.liveness_path != "" { // After the routes so they can be overridden
+		r.HandleFunc(s.liveness_path, s.liveness).Methods("GET")
+	}
+	if s.readiness_path != "" {
+		r.HandleFunc(s.readiness_path, s.readiness).Methods("GET")
+	}
 	return r
 }
 
-func makeHandlerFunc(n RouteNode, handler RouteNodeHandler, s Server, path string, middleware []MiddlewareFunc) http.HandlerFunc {
+func makeHandlerFunc(n RouteNode, handler RouteNodeHandler, s Server, path string, middleware []MiddlewareEntry) http.HandlerFunc {
 	p := s.DepsProvider()
 	h := func(w http.ResponseWriter, r *http.Request) {
 		start_time := time.Now()
-		deps := p(r)()
-		log := deps.Logger()
-		log.Infof("Got request at [ %s ]", path)
+		deps := ioc.WithContext(r.Context(), p(r)()) // Stores see the request's cancellation
+		log := deps.Logger().With(ioc.Fields{ioc.FieldPath: path})
+		log.Debugf("%s request", r.Method)
 
+		parent, _ := ioc.ParseTraceParent(r.Header.Get(ioc.TraceParentHeader))
+		span := deps.Tracer().Start(r.Method+" "+path, parent)
+		span.SetAttributes(ioc.Fields{"http.method": r.Method, "http.route": path})
 		req := NewRequest(deps, r)
+		req.(*request).trace = span.Context()
 		resp := NewResponse(w)
+		resp.(*response).log = log
+		resp.ResponseWriter().Header().Set(XFrameOptions, DefaultXFrameOptions)             // Turn off frmes for older browsers
+		resp.ResponseWriter().Header().Set(XContentTypeOptions, DefaultXContentTypeOptions) // Use explicit content types
 		defer func() {
 			if recoverErr := recover(); recoverErr != nil {
-				log.Infof("\n\n\n***\n***\n***\n\nRecovered in [ %s ]\n\n\n\n", path)
-				log.Infof("\n\n\n[ %v ]\n\n\n\n", recoverErr)
-				log.Infof("\n\n\n%s\n\n\n***\n***\n***\n\n\n", debug.Stack())
+				span.SetError(fmt.Errorf("%v", recoverErr))
+				log.With(ioc.Fields{
+					ioc.FieldError: fmt.Sprintf("%v", recoverErr),
+					ioc.FieldStack: string(debug.Stack()),
+				}).Errorf("recovered from panic")
 				if err := resp.Fail(errors.New(fmt.Sprintf("%s", recoverErr))); err != nil {
 				} // Attempt to write server fault failed
 			} // Push the response to the client
-			resp.ResponseWriter().Header().Set(XFrameOptions, DefaultXFrameOptions)             // Turn off frmes for older browsers
-			resp.ResponseWriter().Header().Set(XContentTypeOptions, DefaultXContentTypeOptions) // Use explicit content types
 			duration := time.Since(start_time)
 			resp.ResponseWriter().Header().Set(XRequestLatency, fmt.Sprintf("%s", duration))
+			labels := ioc.Labels{"path": path, "method": r.Method}
+			deps.Metrics().Observe(ioc.MetricHttpRequestSeconds, duration.Seconds(), labels)
+			labels["status"] = strconv.Itoa(resp.Recorder().Code)
+			deps.Metrics().Add(ioc.MetricHttpRequests, 1, labels)
+			span.SetAttributes(ioc.Fields{"http.status_code": resp.Recorder().Code})
+			span.End()
 
 			resp.Flush()
 		}()
-		t := handler.Handler()
+		wrapMiddleware(handler.Handler(), middleware)(req, resp)
+	}
+	return h
+}
 
-		for _, m := range middleware {
-			t = m(t)
-		}
-		t(req, resp)
+// wrapMiddleware wraps h in the middleware, outermost first
+func wrapMiddleware(h ApiFunc, middleware []MiddlewareEntry) ApiFunc {
+	for i := len(middleware) - 1; i >= 0; i-- { // Wrap inside out so the first runs first
+		h = middleware[i].Handler(h)
 	}
 	return h
 }
@@ -0,0 +1,157 @@
+package apiserver
+
+import (
+	"fmt"
+	j "github.com/vizidrix/jose"
+	"github.com/xzeus/cqrs/ioc"
+	"time"
+)
+
+// revoked_prefix namespaces the token ids denied in the CacheStore
+const revoked_prefix = "apiserver:revoked:"
+
+// SessionConfig describes the session tokens issued by the Session routes,
+// the embedded TokenConfig verifies them when presented
+type SessionConfig struct {
+	TokenConfig
+	// Lifetime of each issued token, defaults to an hour
+	Lifetime time.Duration
+	// Modifiers maps the claims of a new token onto the jose modifiers passed
+	// to Crypto.EncodeToken which signs it
+	Modifiers func(*Claims) []j.TokenModifier
+}
+
+// SessionToken is the body of the Session routes' responses
+type SessionToken struct {
+	Token     string `json:"token"`
+	SessionId string `json:"session_id"`
+	ExpiresAt int64  `json:"expires_at"`
+}
+
+func (c SessionConfig) key() string {
+	return c.keys()[0]
+}
+
+func (c SessionConfig) lifetime() time.Duration {
+	if c.Lifetime <= 0 {
+		return time.Hour
+	}
+	return c.Lifetime
+}
+
+// SessionId reads the session aggregate id from the request's verified
+// session token
+func (c SessionConfig) SessionId(req Request) (int64, error) {
+	t, err := req.GetToken(c.key())
+	if err != nil {
+		return 0, err
+	}
+	return Int64(t.GetId())
+}
+
+// Issue signs a token for the session aggregate, an empty subject issues an
+// anonymous token
+func (c SessionConfig) Issue(deps ioc.Dependencies, session_id int64, subject string) (*SessionToken, error) {
+	now := time.Unix(0, deps.Time().Now())
+	claims := &Claims{
+		Id:        Hex64(session_id),
+		TokenId:   Hex64(deps.Crypto().RandInt64()),
+		Subject:   subject,
+		Issuer:    c.Issuer,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(c.lifetime()).Unix(),
+	}
+	if c.Audience != "" {
+		claims.Audience = Audience{c.Audience}
+	}
+	var mods []j.TokenModifier
+	if c.Modifiers != nil {
+		mods = c.Modifiers(claims)
+	}
+	token, err := deps.Crypto().EncodeToken(mods...)
+	if err != nil {
+		return nil, err
+	}
+	return &SessionToken{
+		Token:     string(token),
+		SessionId: claims.Id,
+		ExpiresAt: claims.ExpiresAt,
+	}, nil
+}
+
+// IssueFor signs a token for the request's session, a verified token's
+// session is kept so that signing in doesn't change the session aggregate
+func (c SessionConfig) IssueFor(req Request, subject string) (*SessionToken, error) {
+	session_id, err := c.SessionId(req)
+	if err != nil { // Start a new anonymous session
+		session_id = req.Deps().Crypto().RandInt64()
+	}
+	return c.Issue(req.Deps(), session_id, subject)
+}
+
+// RevokeToken denies the token until it expires, tokens without a jti can't
+// be revoked
+func RevokeToken(deps ioc.Dependencies, claims *Claims) error {
+	if claims.TokenId == "" {
+		return ErrInvalidToken
+	}
+	return deps.CacheStore().Set(revoked_prefix+claims.TokenId, claims.ExpiresAt)
+}
+
+// IsRevoked reports whether the token was denied by RevokeToken
+func IsRevoked(deps ioc.Dependencies, claims *Claims) bool {
+	if claims.TokenId == "" {
+		return false
+	}
+	var expires int64
+	return deps.CacheStore().Get(revoked_prefix+claims.TokenId, &expires) == nil
+}
+
+// Session defines the routes which issue, refresh and revoke session tokens
+//
+//	POST {path}          issues a token, keeping the session of a valid token
+//	POST {path}/refresh  replaces a valid token with a new one
+//	POST {path}/revoke   denies a valid token
+func Session(path string, config SessionConfig, mods ...func(RouteNodeHandler)) func(RouteNode) {
+	config.Revocable = true
+	load := LoadTokens(config.TokenConfig)
+	require := RequireToken(config.key())
+	issue := func(req Request, resp Response) {
+		subject := ""
+		if claims, err := req.GetClaims(config.key()); err == nil {
+			subject = claims.Subject
+		}
+		respondSession(req, resp, config, subject)
+	}
+	refresh := func(req Request, resp Response) {
+		claims, _ := req.GetClaims(config.key())
+		if err := RevokeToken(req.Deps(), claims); err != nil && err != ErrInvalidToken {
+			resp.Fail(err)
+			return
+		}
+		respondSession(req, resp, config, claims.Subject)
+	}
+	revoke := func(req Request, resp Response) {
+		claims, _ := req.GetClaims(config.key())
+		if err := RevokeToken(req.Deps(), claims); err != nil {
+			resp.Error(fmt.Sprintf("unable to revoke [ %s ]", err), 40120, 400, err)
+			return
+		}
+		resp.Empty(204)
+	}
+	return func(r RouteNode) {
+		r.AppendHandler(NewHandler(path, []string{"POST", "OPTIONS"}, load(issue), mods...))
+		r.AppendHandler(NewHandler(path+"/refresh", []string{"POST", "OPTIONS"}, load(require(refresh)), mods...))
+		r.AppendHandler(NewHandler(path+"/revoke", []string{"POST", "OPTIONS"}, load(require(revoke)), mods...))
+	}
+}
+
+func respondSession(req Request, resp Response, config SessionConfig, subject string) {
+	token, err := config.IssueFor(req, subject)
+	if err != nil {
+		req.Deps().Logger().With(ioc.Fields{ioc.FieldError: err}).Errorf("unable to issue session token")
+		resp.Fail(err)
+		return
+	}
+	resp.Json(token, 200)
+}
@@ -0,0 +1,81 @@
+package apiserver_test
+
+import (
+	"encoding/json"
+	j "github.com/vizidrix/jose"
+	. "github.com/xzeus/cqrs/apiserver"
+	. "github.com/xzeus/cqrs/testing"
+	"github.com/xzeus/cqrs/testing/testdeps"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func sessionServer(t *testing.T, config SessionConfig) (*httptest.Server, func() *Claims) {
+	deps := testdeps.NewDependencies()
+	var issued *Claims
+	deps.Mock_Crypto.Mock_EncodeToken = func(m *testdeps.Mock_Crypto, mods ...j.TokenModifier) ([]byte, error) {
+		data, err := json.Marshal(issued)
+		return []byte(unsignedToken(string(data))), err
+	}
+	deps.Mock_Crypto.Mock_DecodeToken = func(m *testdeps.Mock_Crypto, token []byte, mods ...j.TokenModifier) (*j.TokenDef, error) {
+		return j.Decode(token, j.RemoveConstraints(j.None_Algo))
+	}
+	config.Modifiers = func(claims *Claims) []j.TokenModifier {
+		issued = claims
+		return nil
+	}
+	s, err := NewServer(testProvider(deps))
+	Ok(t, err)
+	s.Define(Session("session", config))
+	return httptest.NewServer(s.BuildRouter()), func() *Claims { return issued }
+}
+
+func postSession(t *testing.T, test_server *httptest.Server, path string, token string) (int, *SessionToken) {
+	r, _ := http.NewRequest("POST", test_server.URL+path, nil)
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	res, err := http.DefaultClient.Do(r)
+	Ok(t, err)
+	defer res.Body.Close()
+	result := &SessionToken{}
+	json.NewDecoder(res.Body).Decode(result)
+	return res.StatusCode, result
+}
+
+func Test_Should_issue_refresh_and_revoke_session_tokens(t *testing.T) {
+	test_server, last := sessionServer(t, SessionConfig{TokenConfig: TokenConfig{Issuer: "here"}})
+	defer test_server.Close()
+	post := func(path string, token string) (int, *SessionToken) {
+		return postSession(t, test_server, path, token)
+	}
+
+	status, anonymous := post("/session", "")
+	Equals(t, 200, status, "should issue an anonymous token")
+	issued := last()
+	Equals(t, "here", issued.Issuer, "should set the issuer")
+	Equals(t, anonymous.SessionId, issued.Id, "should bind the token to the session")
+	Assert(t, issued.TokenId != "", "should set a token id to revoke by")
+
+	status, refreshed := post("/session/refresh", anonymous.Token)
+	Equals(t, 200, status, "should refresh the token")
+	NotEquals(t, anonymous.Token, refreshed.Token, "should issue a new token")
+	status, _ = post("/session/refresh", anonymous.Token)
+	Equals(t, 401, status, "should reject the refreshed token")
+
+	status, _ = post("/session/revoke", refreshed.Token)
+	Equals(t, 204, status, "should revoke the token")
+	status, _ = post("/session/revoke", refreshed.Token)
+	Equals(t, 401, status, "should reject the revoked token")
+}
+
+func Test_Should_keep_the_session_of_a_configured_key(t *testing.T) {
+	test_server, _ := sessionServer(t, SessionConfig{TokenConfig: TokenConfig{Keys: []string{"visitor"}}})
+	defer test_server.Close()
+	status, anonymous := postSession(t, test_server, "/session", "")
+	Equals(t, 200, status, "should issue an anonymous token")
+	status, refreshed := postSession(t, test_server, "/session/refresh", anonymous.Token)
+	Equals(t, 200, status, "should refresh the token")
+	Equals(t, anonymous.SessionId, refreshed.SessionId, "should keep the session")
+}
@@ -0,0 +1,154 @@
+package apiserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/xzeus/cqrs/ioc"
+	"io"
+	"mime"
+	"net/http"
+)
+
+// StreamWriter writes straight through to the client, flushing each write
+// so that it isn't held by the server's buffers
+type StreamWriter struct {
+	writer  http.ResponseWriter
+	flusher http.Flusher
+}
+
+func (w *StreamWriter) Write(p []byte) (int, error) {
+	n, err := w.writer.Write(p)
+	if err == nil && w.flusher != nil {
+		w.flusher.Flush()
+	}
+	return n, err
+}
+
+// Stream sends the buffered and OnHeaders headers with the status and
+// returns a writer to the client, the response can no longer be reset so failures part way
+// through must be reported within the content
+func (r *response) Stream(content_type string, status int) (*StreamWriter, error) {
+	if r.flushed {
+		return nil, ErrResponseFlushed
+	}
+	r.flushed = true
+	r.recorder.WriteHeader(status) // Keeps the status for metrics and logs
+	r.sendHeaders()
+	r.writer.Header().Set("Content-Type", content_type)
+	r.writer.WriteHeader(status)
+	flusher, _ := r.writer.(http.Flusher)
+	return &StreamWriter{writer: r.writer, flusher: flusher}, nil
+}
+
+func (r *response) Detach() (http.ResponseWriter, error) {
+	if r.flushed {
+		return nil, ErrResponseFlushed
+	}
+	r.flushed = true
+	r.recorder.WriteHeader(http.StatusSwitchingProtocols)
+	return r.writer, nil
+}
+
+// JsonArrayWriter streams a json array one element at a time
+type JsonArrayWriter struct {
+	writer io.Writer
+	count  int
+}
+
+func (w *JsonArrayWriter) Write(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	sep := ","
+	if w.count == 0 {
+		sep = "["
+	}
+	w.count++
+	_, err = w.writer.Write(append([]byte(sep), data...))
+	return err
+}
+
+// Close ends the array and must be called even when nothing was written
+func (w *JsonArrayWriter) Close() error {
+	end := "]"
+	if w.count == 0 {
+		end = "[]"
+	}
+	_, err := io.WriteString(w.writer, end)
+	return err
+}
+
+func (r *response) JsonArray(status int) (*JsonArrayWriter, error) {
+	w, err := r.Stream("application/json; charset=utf-8", status)
+	if err != nil {
+		return nil, err
+	}
+	return &JsonArrayWriter{writer: w}, nil
+}
+
+// NDJsonWriter streams newline delimited json, one value per line
+type NDJsonWriter struct {
+	encoder *json.Encoder
+}
+
+func (w *NDJsonWriter) Write(v interface{}) error {
+	return w.encoder.Encode(v)
+}
+
+func (r *response) NDJson(status int) (*NDJsonWriter, error) {
+	w, err := r.Stream("application/x-ndjson", status)
+	if err != nil {
+		return nil, err
+	}
+	return &NDJsonWriter{encoder: json.NewEncoder(w)}, nil
+}
+
+// Download streams the content as an attachment named file_name
+func (r *response) Download(file_name string, mime_type string, content io.Reader) error {
+	disposition := mime.FormatMediaType("attachment", map[string]string{"filename": file_name})
+	if disposition == "" { // A name that can't be encoded
+		disposition = "attachment"
+	}
+	r.recorder.Header().Set("Content-Disposition", disposition)
+	w, err := r.Stream(mime_type, 200)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, content)
+	return err
+}
+
+// BlobDownload streams the blob with the id of the named hex parameter from
+// a store implementing ioc.BlobStoreStreamer, others are read whole into
+// memory first
+func BlobDownload(key string, param string, file_name string, mime_type string) ApiFunc {
+	return func(req Request, resp Response) {
+		id, err := req.HexParam(param)
+		if err != nil {
+			ParamError(resp, param, err)
+			return
+		}
+		store := req.Deps().BlobStore()
+		var content io.Reader
+		if streamer, ok := store.(ioc.BlobStoreStreamer); ok {
+			reader, err := streamer.Open(key, id)
+			if err != nil {
+				resp.View(nil, err)
+				return
+			}
+			defer reader.Close()
+			content = reader
+		} else {
+			data := []byte{}
+			if err := store.Get(key, id, &data); err != nil {
+				resp.View(nil, err)
+				return
+			}
+			content = bytes.NewReader(data)
+		}
+		if err := resp.Download(file_name, mime_type, content); err != nil {
+			req.Deps().Logger().With(ioc.Fields{ioc.FieldError: err}).Warnf("download interrupted")
+		}
+	}
+}
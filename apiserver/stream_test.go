@@ -0,0 +1,84 @@
+package apiserver_test
+
+import (
+	. "github.com/xzeus/cqrs/apiserver"
+	. "github.com/xzeus/cqrs/testing"
+	"github.com/xzeus/cqrs/testing/testdeps"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func streamBody(t *testing.T, h ApiFunc) (*http.Response, string) {
+	s, err := NewServer(testProvider(testdeps.NewDependencies()))
+	Ok(t, err)
+	s.Define(View("stream", h))
+	test_server := httptest.NewServer(s.BuildRouter())
+	defer test_server.Close()
+	res, err := http.Get(test_server.URL + "/stream")
+	Ok(t, err)
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	Ok(t, err)
+	return res, string(body)
+}
+
+func Test_Should_stream_json_array_and_ndjson(t *testing.T) {
+	res, body := streamBody(t, func(req Request, resp Response) {
+		w, err := resp.JsonArray(200)
+		Ok(t, err)
+		w.Write(map[string]int{"a": 1})
+		w.Write(map[string]int{"b": 2})
+		w.Close()
+		Equals(t, ErrResponseFlushed, resp.Reset(), "should not reset a streamed response")
+	})
+	Equals(t, 200, res.StatusCode, "should send the status")
+	Equals(t, `[{"a":1},{"b":2}]`, body, "should stream the array")
+	Equals(t, "nosniff", res.Header.Get("X-Content-Type-Options"), "should keep the default headers")
+
+	res, body = streamBody(t, func(req Request, resp Response) {
+		w, _ := resp.NDJson(200)
+		w.Write(1)
+		w.Write("two")
+	})
+	Equals(t, "application/x-ndjson", res.Header.Get("Content-Type"), "should set the content type")
+	Equals(t, "1\n\"two\"\n", body, "should write a value per line")
+}
+
+func Test_Should_still_reset_a_buffered_response(t *testing.T) {
+	res, body := streamBody(t, func(req Request, resp Response) {
+		resp.Text("partial", 200)
+		resp.Fail(nil)
+	})
+	Equals(t, 500, res.StatusCode, "should replace the buffered response")
+	Assert(t, body != "partial", "should have dropped the partial body")
+}
+
+func Test_Should_send_hooked_headers_when_a_stream_starts(t *testing.T) {
+	res, _ := streamBody(t, func(req Request, resp Response) {
+		resp.OnHeaders(func(header http.Header) { header.Set("X-Hooked", "yes") })
+		resp.Header().Set("X-Dropped", "yes")
+		resp.Reset()
+		w, err := resp.NDJson(200)
+		Ok(t, err)
+		w.Write(1)
+	})
+	Equals(t, "yes", res.Header.Get("X-Hooked"), "should run the hook as the stream starts")
+	Equals(t, "", res.Header.Get("X-Dropped"), "should have reset the buffered header")
+}
+
+func Test_Should_name_downloads_with_encoded_file_names(t *testing.T) {
+	for _, name := range []string{"report.csv", `q1 "final".csv`, "résumé.pdf"} {
+		res, body := streamBody(t, func(req Request, resp Response) {
+			Ok(t, resp.Download(name, "text/plain", strings.NewReader("content")))
+		})
+		Equals(t, "content", body, "should stream the content")
+		disposition, params, err := mime.ParseMediaType(res.Header.Get("Content-Disposition"))
+		Ok(t, err)
+		Equals(t, "attachment", disposition, "should be an attachment")
+		Equals(t, name, params["filename"], "should round trip the name")
+	}
+}
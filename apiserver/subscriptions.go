@@ -0,0 +1,102 @@
+package apiserver
+
+import (
+	"context"
+	"fmt"
+	"github.com/xzeus/cqrs"
+	"github.com/xzeus/cqrs/ioc"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// SubscriptionRunner is a Runner delivering the messages of a subscriber to
+// Handle one at a time, those already buffered when it's stopped are still
+// delivered before Run returns
+type SubscriptionRunner struct {
+	Name       string
+	Subscriber ioc.Subscriber
+	Filter     func(cqrs.Message) bool
+	Handle     func(ctx context.Context, m cqrs.Message)
+	Time       ioc.Time // Defaults to the system clock
+	handling   int64    // Timestamp of the message being handled
+	lock       sync.Mutex
+	messages   <-chan cqrs.Message // Buffered by the subscriber while running
+}
+
+func (r *SubscriptionRunner) now() int64 {
+	if r.Time != nil {
+		return r.Time.Now()
+	}
+	return time.Now().UnixNano()
+}
+
+// Run subscribes until ctx is cancelled, the subscriber dropping it for
+// falling behind is returned as an error as messages were lost
+func (r *SubscriptionRunner) Run(ctx context.Context) error {
+	messages, cancel := r.Subscriber.Subscribe(r.Filter)
+	r.lock.Lock()
+	r.messages = messages
+	r.lock.Unlock()
+	defer func() {
+		r.lock.Lock()
+		r.messages = nil
+		r.lock.Unlock()
+	}()
+	stop := ctx.Done()
+	for {
+		select {
+		case <-stop:
+			cancel() // Closes messages once the buffer is read
+			stop = nil
+		case m, open := <-messages:
+			if !open {
+				if stop == nil {
+					return nil
+				}
+				return fmt.Errorf("subscription [ %s ] fell behind", r.Name)
+			}
+			atomic.StoreInt64(&r.handling, m.GetTimestamp())
+			r.Handle(context.Background(), m) // Drained messages still complete
+			atomic.StoreInt64(&r.handling, cqrs.NoAssignedTime)
+		}
+	}
+}
+
+// Lag is how long ago the oldest message not yet handled was published,
+// that's the one being handled as those queued behind it are newer, it's
+// zero while idle
+func (r *SubscriptionRunner) Lag() time.Duration {
+	ts := atomic.LoadInt64(&r.handling)
+	if ts == cqrs.NoAssignedTime {
+		return 0
+	}
+	return time.Duration(r.now() - ts)
+}
+
+// Queued is the count of messages received but not yet handled, including
+// the one being handled
+func (r *SubscriptionRunner) Queued() int {
+	r.lock.Lock()
+	queued := len(r.messages)
+	r.lock.Unlock()
+	if atomic.LoadInt64(&r.handling) != cqrs.NoAssignedTime {
+		queued++
+	}
+	return queued
+}
+
+// SubscriptionLag fails readiness while any of the runners' oldest unhandled
+// message is further behind than max or max_queued messages are waiting,
+// zero leaves either unchecked
+func SubscriptionLag(max time.Duration, max_queued int, runners ...*SubscriptionRunner) HealthCheck {
+	return func(ctx context.Context, deps ioc.Dependencies) error {
+		for _, r := range runners {
+			lag, queued := r.Lag(), r.Queued()
+			if (max > 0 && lag > max) || (max_queued > 0 && queued > max_queued) {
+				return fmt.Errorf("subscription [ %s ] is %s behind with %d queued", r.Name, lag, queued)
+			}
+		}
+		return nil
+	}
+}
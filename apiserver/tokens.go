@@ -0,0 +1,233 @@
+package apiserver
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	j "github.com/vizidrix/jose"
+	"github.com/xzeus/cqrs/ioc"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var (
+	ErrMalformedToken   = errors.New("malformed token")
+	ErrTokenExpired     = errors.New("token expired")
+	ErrTokenNotYetValid = errors.New("token not yet valid")
+	ErrTokenIssuer      = errors.New("token issuer not accepted")
+	ErrTokenAudience    = errors.New("token audience not accepted")
+	ErrTokenRevoked     = errors.New("token revoked")
+)
+
+// TokenSource reads the raw token for the key from the request, returning
+// an empty string when it isn't present
+type TokenSource func(r *http.Request, key string) string
+
+// FromBearer reads the Authorization header's bearer token for the listed
+// keys only, as a request carries at most one
+func FromBearer(keys ...string) TokenSource {
+	return func(r *http.Request, key string) string {
+		found := false
+		for _, k := range keys {
+			found = found || k == key
+		}
+		v := r.Header.Get(authorization)
+		if !found || len(v) < 7 || !strings.EqualFold(v[:7], "Bearer ") {
+			return ""
+		}
+		return strings.TrimSpace(v[7:])
+	}
+}
+
+// FromHeader reads the x-{key}-token header i.e. x-session-token
+func FromHeader() TokenSource {
+	return func(r *http.Request, key string) string {
+		return r.Header.Get("x-" + key + "-token")
+	}
+}
+
+// FromCookie reads the {key}-token cookie i.e. session-token, browsers send
+// cookies with cross site requests so it's only read when the Origin is the
+// server's own or one of origins, without an Origin the Sec-Fetch-Site must
+// be same-origin or the request a GET, the cookie should also be SameSite
+func FromCookie(origins ...string) TokenSource {
+	trusted := CORSConfig{}
+	for _, o := range origins {
+		if o != "*" { // Would trust every site
+			trusted.AllowedOrigins = append(trusted.AllowedOrigins, o)
+		}
+	}
+	return func(r *http.Request, key string) string {
+		if !sameOrigin(r, trusted) {
+			return ""
+		}
+		if c, err := r.Cookie(key + "-token"); err == nil {
+			return c.Value
+		}
+		return ""
+	}
+}
+
+func sameOrigin(r *http.Request, trusted CORSConfig) bool {
+	if origin := r.Header.Get(CORS_Origin); origin != "" {
+		return trusted.AllowsOrigin(r, origin)
+	}
+	switch r.Header.Get("Sec-Fetch-Site") {
+	case "same-origin", "none":
+		return true
+	case "": // Clients without fetch metadata only omit Origin for reads
+		return r.Method == "GET" || r.Method == "HEAD"
+	}
+	return false
+}
+
+// FromQuery reads the query parameter, used for redirects which can't set
+// headers such as an oauth state param
+func FromQuery(param string) TokenSource {
+	return func(r *http.Request, key string) string {
+		return r.URL.Query().Get(param)
+	}
+}
+
+// Audience accepts the aud claim as either a string or a list of strings
+type Audience []string
+
+func (a *Audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = Audience{single}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(data, &many); err != nil {
+		return err
+	}
+	*a = Audience(many)
+	return nil
+}
+
+func (a Audience) Contains(audience string) bool {
+	for _, v := range a {
+		if v == audience {
+			return true
+		}
+	}
+	return false
+}
+
+// Claims are the registered claims of a token along with the session id,
+// times are unix seconds
+type Claims struct {
+	// Id is the private jid claim holding the session aggregate id read by
+	// TokenDef.GetId, it isn't the registered jti claim
+	Id        string   `json:"jid,omitempty"`
+	TokenId   string   `json:"jti,omitempty"`
+	Subject   string   `json:"sub,omitempty"`
+	Issuer    string   `json:"iss,omitempty"`
+	Audience  Audience `json:"aud,omitempty"`
+	ExpiresAt int64    `json:"exp,omitempty"`
+	NotBefore int64    `json:"nbf,omitempty"`
+	IssuedAt  int64    `json:"iat,omitempty"`
+}
+
+// ParseClaims reads the claims from a compact token without verifying it
+func ParseClaims(raw string) (*Claims, error) {
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return nil, ErrMalformedToken
+	}
+	data, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return nil, ErrMalformedToken
+	}
+	claims := &Claims{}
+	if err := json.Unmarshal(data, claims); err != nil {
+		return nil, ErrMalformedToken
+	}
+	return claims, nil
+}
+
+// TokenConfig describes the tokens accepted by LoadTokens
+type TokenConfig struct {
+	// Keys are the tokens to look for, defaults to session
+	Keys []string
+	// Sources are tried in order for each key, defaults to the bearer token
+	// for the first key followed by the header, cookies are opt in with
+	// FromCookie
+	Sources []TokenSource
+	// Issuer is the required iss claim, empty accepts any issuer
+	Issuer string
+	// Audience must be listed in the aud claim, empty accepts any audience
+	Audience string
+	// Leeway allows for clock skew when checking exp and nbf
+	Leeway time.Duration
+	// Modifiers are passed to Crypto.DecodeToken which verifies the signature
+	Modifiers []j.TokenModifier
+	// Revocable rejects tokens denied by RevokeToken
+	Revocable bool
+}
+
+func (c TokenConfig) keys() []string {
+	if len(c.Keys) == 0 {
+		return []string{"session"}
+	}
+	return c.Keys
+}
+
+func (c TokenConfig) sources() []TokenSource {
+	if len(c.Sources) == 0 {
+		return []TokenSource{FromBearer(c.keys()[0]), FromHeader()}
+	}
+	return c.Sources
+}
+
+// Verify decodes the token with the signature checks of Crypto.DecodeToken
+// and then checks it's expiry, issuer and audience
+func (c TokenConfig) Verify(deps ioc.Dependencies, raw string) (*j.TokenDef, *Claims, error) {
+	claims, err := ParseClaims(raw)
+	if err != nil {
+		return nil, nil, err
+	}
+	t, err := deps.Crypto().DecodeToken([]byte(raw), c.Modifiers...)
+	if err != nil {
+		return nil, nil, err
+	}
+	if errs := t.Validate(); len(errs) > 0 {
+		return nil, nil, ErrInvalidToken
+	}
+	if err := c.Check(deps, claims); err != nil {
+		return nil, nil, err
+	}
+	if c.Issuer != "" && claims.Issuer != c.Issuer {
+		return nil, nil, ErrTokenIssuer
+	}
+	if c.Audience != "" && !claims.Audience.Contains(c.Audience) {
+		return nil, nil, ErrTokenAudience
+	}
+	return t, claims, nil
+}
+
+// Check repeats the expiry and revocation checks of Verify for a token held
+// beyond it's request such as by a websocket
+func (c TokenConfig) Check(deps ioc.Dependencies, claims *Claims) error {
+	now := time.Unix(0, deps.Time().Now())
+	if claims.ExpiresAt != 0 && now.After(time.Unix(claims.ExpiresAt, 0).Add(c.Leeway)) {
+		return ErrTokenExpired
+	}
+	if claims.NotBefore != 0 && now.Add(c.Leeway).Before(time.Unix(claims.NotBefore, 0)) {
+		return ErrTokenNotYetValid
+	}
+	if c.Revocable && IsRevoked(deps, claims) {
+		return ErrTokenRevoked
+	}
+	return nil
+}
+
+// ExpiresIn is how long until the token expires, false if it doesn't
+func (c TokenConfig) ExpiresIn(deps ioc.Dependencies, claims *Claims) (time.Duration, bool) {
+	if claims.ExpiresAt == 0 {
+		return 0, false
+	}
+	return time.Unix(claims.ExpiresAt, 0).Add(c.Leeway).Sub(time.Unix(0, deps.Time().Now())), true
+}
@@ -0,0 +1,86 @@
+package apiserver_test
+
+import (
+	"encoding/base64"
+	j "github.com/vizidrix/jose"
+	. "github.com/xzeus/cqrs/apiserver"
+	. "github.com/xzeus/cqrs/testing"
+	"github.com/xzeus/cqrs/testing/testdeps"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func unsignedToken(claims string) string {
+	return "eyJ0eXAiOiJKV1QiLCJhbGciOiJub25lIn0." + base64.RawURLEncoding.EncodeToString([]byte(claims)) + "."
+}
+
+func getWithToken(t *testing.T, config TokenConfig, set func(*http.Request)) int {
+	deps := testdeps.NewDependencies()
+	deps.Mock_Crypto.Mock_DecodeToken = func(m *testdeps.Mock_Crypto, token []byte, mods ...j.TokenModifier) (*j.TokenDef, error) {
+		return j.Decode(token, j.RemoveConstraints(j.None_Algo))
+	}
+	s, err := NewServer(testProvider(deps))
+	Ok(t, err)
+	me := func(req Request, resp Response) { resp.Empty(204) }
+	s.Define(
+		Sub("private",
+			View("me", LoadTokens(config)(RequireToken("session")(me))),
+		),
+	)
+	test_server := httptest.NewServer(s.BuildRouter())
+	defer test_server.Close()
+	r, _ := http.NewRequest("GET", test_server.URL+"/private/me", nil)
+	set(r)
+	res, err := http.DefaultClient.Do(r)
+	Ok(t, err)
+	res.Body.Close()
+	return res.StatusCode
+}
+
+func Test_Should_accept_bearer_token_with_expected_audience(t *testing.T) {
+	exp := time.Now().Add(time.Hour).Unix()
+	token := unsignedToken(`{"aud":["api"],"exp":` + strconv.FormatInt(exp, 10) + `}`)
+	status := getWithToken(t, TokenConfig{Audience: "api"}, func(r *http.Request) {
+		r.Header.Set("Authorization", "Bearer "+token)
+	})
+	Equals(t, 204, status, "should have verified the token")
+}
+
+func Test_Should_reject_expired_and_foreign_tokens(t *testing.T) {
+	expired := unsignedToken(`{"exp":1}`)
+	status := getWithToken(t, TokenConfig{}, func(r *http.Request) {
+		r.Header.Set("x-session-token", expired)
+	})
+	Equals(t, 401, status, "should reject the expired token")
+	foreign := unsignedToken(`{"iss":"elsewhere"}`)
+	status = getWithToken(t, TokenConfig{Issuer: "here", Sources: []TokenSource{FromCookie()}}, func(r *http.Request) {
+		r.AddCookie(&http.Cookie{Name: "session-token", Value: foreign})
+	})
+	Equals(t, 401, status, "should reject the foreign issuer")
+	status = getWithToken(t, TokenConfig{}, func(r *http.Request) {})
+	Equals(t, 401, status, "should require a token")
+}
+
+func Test_Should_only_read_cookies_when_opted_in_from_trusted_origins(t *testing.T) {
+	token := unsignedToken(`{"exp":` + strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10) + `}`)
+	cookie := func(origin string) func(*http.Request) {
+		return func(r *http.Request) {
+			r.AddCookie(&http.Cookie{Name: "session-token", Value: token})
+			if origin != "" {
+				r.Header.Set("Origin", origin)
+			}
+		}
+	}
+	Equals(t, 401, getWithToken(t, TokenConfig{}, cookie("")), "should ignore cookies by default")
+	config := TokenConfig{Sources: []TokenSource{FromCookie("https://app.example.com")}}
+	Equals(t, 204, getWithToken(t, config, cookie("")), "should read the cookie of a same origin read")
+	Equals(t, 204, getWithToken(t, config, cookie("https://app.example.com")), "should read the cookie from a trusted origin")
+	Equals(t, 401, getWithToken(t, config, cookie("https://evil.example.com")), "should ignore the cookie from a foreign origin")
+	Equals(t, 401, getWithToken(t, config, func(r *http.Request) {
+		cookie("")(r)
+		r.Header.Set("Sec-Fetch-Site", "cross-site")
+	}), "should ignore the cookie of a cross site request")
+}
@@ -0,0 +1,330 @@
+package apiserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/gorilla/websocket"
+	"github.com/xzeus/cqrs"
+	"github.com/xzeus/cqrs/ioc"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Ops of the messages exchanged over the websocket gateway
+const (
+	WS_Command     = "command"
+	WS_Subscribe   = "subscribe"
+	WS_Unsubscribe = "unsubscribe"
+	WS_Ack         = "ack"
+	WS_Event       = "event"
+)
+
+// WebSocketConfig describes the domains served by a websocket gateway and
+// who may connect to it
+type WebSocketConfig struct {
+	Domains []cqrs.Domain
+	// Tokens are loaded when connecting, the first key's token is required
+	// unless Anonymous is set
+	Tokens    TokenConfig
+	Anonymous bool
+	// Origins allowed to connect, empty allows only the server's own origin
+	Origins CORSConfig
+	// Authorize is checked for each subscribed event, nil sends all
+	Authorize func(Request, cqrs.Message) bool
+	// Heartbeat is the interval of pings which keep idle connections open,
+	// defaults to 30 seconds
+	Heartbeat time.Duration
+}
+
+// WebSocketMessage is sent both ways, clients send commands, subscribe and
+// unsubscribe while the gateway acknowledges each by it's ref and sends the
+// subscribed events
+type WebSocketMessage struct {
+	Op  string `json:"op"`
+	Ref string `json:"ref,omitempty"` // Chosen by the client, echoed on it's ack
+	// Domain is the uri of the command or subscription's domain, it may be
+	// left out when the gateway serves a single domain
+	Domain string `json:"domain,omitempty"`
+	// Name of the command as in it's route i.e. open
+	Name string `json:"name,omitempty"`
+	// Aggregate is the hex id to follow a single aggregate's events
+	Aggregate   string `json:"aggregate,omitempty"`
+	LastEventId string `json:"last_event_id,omitempty"`
+	// Subscription is the ref of the subscribe being ended or the event's
+	Subscription string          `json:"subscription,omitempty"`
+	EventId      string          `json:"event_id,omitempty"`
+	Status       int             `json:"status,omitempty"`
+	Payload      json.RawMessage `json:"payload,omitempty"`
+}
+
+// WebSocket defines GET {path} which upgrades to a gateway for the domains'
+// commands and events, commands go through the same handler and route
+// middleware as SyncCommand so their acks carry the same status and body as
+// the http response, the connection's token is checked again before each
+// command and subscribe and the connection is closed once it expires
+func WebSocket(path string, config WebSocketConfig, mods ...func(RouteNodeHandler)) func(RouteNode) {
+	return func(r RouteNode) {
+		r.AppendHandler(NewHandler(path, []string{"GET"}, webSocketGateway(r, config), mods...))
+	}
+}
+
+func domainKey(uri string, name string) string {
+	return uri + "|" + strings.ToLower(name)
+}
+
+func webSocketGateway(node RouteNode, config WebSocketConfig) ApiFunc {
+	heartbeat := config.Heartbeat
+	if heartbeat <= 0 {
+		heartbeat = 30 * time.Second
+	}
+	var wrap sync.Once // Once serving as routes may add middleware after the gateway
+	commands := make(map[string]ApiFunc)
+	domains := make(map[string]cqrs.Domain)
+	for _, d := range config.Domains {
+		for _, f := range d.Commands() {
+			c := f()
+			h := SyncCommandHandler(c, true)
+			commands[domainKey(d.Uri(), d.MessageName(c))] = h
+			if len(config.Domains) == 1 {
+				commands[domainKey("", d.MessageName(c))] = h
+			}
+		}
+		domains[d.Uri()] = d
+		if len(config.Domains) == 1 {
+			domains[""] = d
+		}
+	}
+	upgrader := websocket.Upgrader{
+		CheckOrigin: func(r *http.Request) bool {
+			origin := r.Header.Get(CORS_Origin)
+			return origin == "" || config.Origins.AllowsOrigin(r, origin)
+		},
+	}
+	gateway := func(req Request, resp Response) {
+		wrap.Do(func() { // Rate limits and the like apply to each command
+			middleware := EffectiveMiddleware(node)
+			for k, h := range commands {
+				commands[k] = wrapMiddleware(h, middleware)
+			}
+		})
+		log := req.Deps().Logger()
+		w, err := resp.Detach()
+		if err != nil {
+			return
+		}
+		conn, err := upgrader.Upgrade(w, req.Request(), nil) // Responds itself on failure
+		if err != nil {
+			log.With(ioc.Fields{ioc.FieldError: err}).Debugf("websocket upgrade failed")
+			return
+		}
+		defer conn.Close()
+		var lock sync.Mutex
+		write := func(m *WebSocketMessage) error {
+			lock.Lock()
+			defer lock.Unlock()
+			conn.SetWriteDeadline(time.Now().Add(heartbeat))
+			return conn.WriteJSON(m)
+		}
+		closeWith := func(code int, reason string) {
+			conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(heartbeat))
+			conn.Close()
+		}
+		claims, _ := req.GetClaims(config.Tokens.keys()[0]) // Nil when anonymous
+		var expired <-chan time.Time
+		if claims != nil {
+			if expires_in, ok := config.Tokens.ExpiresIn(req.Deps(), claims); ok {
+				timer := time.NewTimer(expires_in)
+				defer timer.Stop()
+				expired = timer.C
+			}
+		}
+		ack := func(ref string, f func(Response)) error {
+			recorder := httptest.NewRecorder()
+			r := NewResponse(recorder)
+			f(r)
+			r.Flush()
+			return write(&WebSocketMessage{Op: WS_Ack, Ref: ref, Status: recorder.Code, Payload: recorder.Body.Bytes()})
+		}
+
+		done := make(chan struct{})
+		subscriptions := &wsSubscriptions{stops: make(map[string]chan struct{})}
+		defer func() {
+			close(done)
+			subscriptions.closeAll()
+		}()
+		conn.SetReadDeadline(time.Now().Add(2 * heartbeat))
+		conn.SetPongHandler(func(string) error {
+			return conn.SetReadDeadline(time.Now().Add(2 * heartbeat))
+		})
+		go func() {
+			ticker := time.NewTicker(heartbeat)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-done:
+					return
+				case <-Stopping(req.Request()): // Hijacked so shutdown won't close it
+					closeWith(websocket.CloseGoingAway, "server stopping")
+					return
+				case <-expired:
+					closeWith(websocket.ClosePolicyViolation, ErrTokenExpired.Error())
+					return
+				case <-ticker.C:
+					if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(heartbeat)); err != nil {
+						return
+					}
+				}
+			}
+		}()
+
+		for {
+			m := &WebSocketMessage{}
+			if err := conn.ReadJSON(m); err != nil {
+				log.With(ioc.Fields{ioc.FieldError: err}).Debugf("websocket closed")
+				return
+			}
+			if m.Op == WS_Command || m.Op == WS_Subscribe {
+				if claims != nil {
+					if err := config.Tokens.Check(req.Deps(), claims); err != nil {
+						log.With(ioc.Fields{ioc.FieldError: err}).Debugf("websocket token no longer valid")
+						closeWith(websocket.ClosePolicyViolation, err.Error())
+						return
+					}
+				}
+			}
+			switch m.Op {
+			case WS_Command:
+				h, found := commands[domainKey(m.Domain, m.Name)]
+				if !found {
+					err = ack(m.Ref, func(r Response) { r.Error("unknown command [ "+m.Name+" ]", 40060, 400, nil) })
+					break
+				}
+				r, _ := http.NewRequestWithContext(req.Request().Context(), "POST", req.Request().URL.String(), bytes.NewReader(m.Payload))
+				r.Header = req.Request().Header
+				r.RemoteAddr = req.Request().RemoteAddr // Keyed as the connection's client
+				command_req := withHttpRequest(req, r)
+				err = ack(m.Ref, func(r Response) { h(command_req, r) })
+			case WS_Subscribe:
+				err = subscribe(req, m, domains, config, subscriptions, write, ack)
+			case WS_Unsubscribe:
+				subscriptions.remove(m.Subscription)
+				err = write(&WebSocketMessage{Op: WS_Ack, Ref: m.Ref, Status: 204})
+			default:
+				err = ack(m.Ref, func(r Response) { r.Error("unknown op [ "+m.Op+" ]", 40160, 400, nil) })
+			}
+			if err != nil {
+				log.With(ioc.Fields{ioc.FieldError: err}).Debugf("websocket write failed")
+				return
+			}
+		}
+	}
+	h := gateway
+	if !config.Anonymous {
+		h = RequireToken(config.Tokens.keys()[0])(h)
+	}
+	return LoadTokens(config.Tokens)(h)
+}
+
+// wsSubscriptions are the stop channels of a connection's subscriptions by
+// their ref
+type wsSubscriptions struct {
+	sync.Mutex
+	stops map[string]chan struct{}
+}
+
+func (s *wsSubscriptions) add(ref string) (chan struct{}, bool) {
+	s.Lock()
+	defer s.Unlock()
+	if _, found := s.stops[ref]; found || ref == "" {
+		return nil, false
+	}
+	stop := make(chan struct{})
+	s.stops[ref] = stop
+	return stop, true
+}
+
+// remove stops the subscription, returning false if it already was
+func (s *wsSubscriptions) remove(ref string) bool {
+	s.Lock()
+	defer s.Unlock()
+	stop, found := s.stops[ref]
+	if found {
+		close(stop)
+		delete(s.stops, ref)
+	}
+	return found
+}
+
+func (s *wsSubscriptions) closeAll() {
+	s.Lock()
+	defer s.Unlock()
+	for ref, stop := range s.stops {
+		close(stop)
+		delete(s.stops, ref)
+	}
+}
+
+func subscribe(req Request, m *WebSocketMessage, domains map[string]cqrs.Domain, config WebSocketConfig, subscriptions *wsSubscriptions, write func(*WebSocketMessage) error, ack func(string, func(Response)) error) error {
+	d, found := domains[m.Domain]
+	if !found {
+		return ack(m.Ref, func(r Response) { r.Error("unknown domain [ "+m.Domain+" ]", 40420, 404, nil) })
+	}
+	subscriber, ok := req.Deps().Publisher().(ioc.Subscriber)
+	if !ok {
+		return ack(m.Ref, func(r Response) { r.Error("event streams not supported", 50110, 501, nil) })
+	}
+	var id int64
+	if m.Aggregate != "" {
+		var err error
+		if id, err = Int64(m.Aggregate); err != nil {
+			return ack(m.Ref, func(r Response) { r.Error("invalid aggregate id", 40170, 400, err) })
+		}
+	}
+	filter := newEventFilter(d, m.Aggregate != "", id, nil, config.Authorize)
+	stop, ok := subscriptions.add(m.Ref)
+	if !ok {
+		return ack(m.Ref, func(r Response) { r.Error("subscription ref must be unique", 40180, 400, nil) })
+	}
+	ref := m.Ref
+	go func() {
+		started := false
+		err := filter.follow(req, subscriber, m.LastEventId, func() error {
+			started = true
+			return write(&WebSocketMessage{Op: WS_Ack, Ref: ref, Status: 200})
+		}, func(e cqrs.Message) error {
+			data, err := json.Marshal(NewCommandResult(e))
+			if err != nil {
+				return err
+			}
+			return write(&WebSocketMessage{Op: WS_Event, Subscription: ref, EventId: eventId(e), Payload: data})
+		}, func() error { return nil }, stop, nil)
+		if !subscriptions.remove(ref) { // Ended by the client or connection
+			return
+		}
+		if !started {
+			ack(ref, func(r Response) { r.Error("unable to resume event stream", 50030, 503, err) })
+			return
+		} // Fell behind so tell the client to subscribe again from it's last event
+		write(&WebSocketMessage{Op: WS_Unsubscribe, Subscription: ref})
+	}()
+	return nil
+}
+
+// withHttpRequest shares the verified tokens and trace of req with a request
+// for r
+func withHttpRequest(req Request, r *http.Request) Request {
+	parent, ok := req.(*request)
+	if !ok {
+		return NewRequest(req.Deps(), r)
+	}
+	return &request{
+		deps:    parent.deps,
+		request: r,
+		tokens:  parent.tokens,
+		claims:  parent.claims,
+		trace:   parent.trace,
+	}
+}
@@ -0,0 +1,120 @@
+package apiserver_test
+
+import (
+	"encoding/json"
+	"github.com/gorilla/websocket"
+	j "github.com/vizidrix/jose"
+	"github.com/xzeus/cqrs"
+	. "github.com/xzeus/cqrs/apiserver"
+	"github.com/xzeus/cqrs/ioc"
+	. "github.com/xzeus/cqrs/testing"
+	"github.com/xzeus/cqrs/testing/testdeps"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func Test_Should_ack_commands_and_push_subscribed_events(t *testing.T) {
+	deps := &hubDependencies{testdeps.NewDependencies(), ioc.NewPublisherHub(nil)}
+	s, err := NewServer(testProvider(deps))
+	Ok(t, err)
+	s.Define(WebSocket("ws", WebSocketConfig{Domains: []cqrs.Domain{ticketDomain}, Anonymous: true}))
+	test_server := httptest.NewServer(s.BuildRouter())
+	defer test_server.Close()
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(test_server.URL, "http")+"/ws", nil)
+	Ok(t, err)
+	defer conn.Close()
+	read := func() *WebSocketMessage {
+		m := &WebSocketMessage{}
+		Ok(t, conn.ReadJSON(m))
+		return m
+	}
+
+	Ok(t, conn.WriteJSON(&WebSocketMessage{Op: WS_Subscribe, Ref: "s1"}))
+	m := read()
+	Equals(t, WS_Ack, m.Op, "should acknowledge the subscription")
+	Equals(t, 200, m.Status, "should have subscribed")
+
+	Ok(t, conn.WriteJSON(&WebSocketMessage{Op: WS_Command, Ref: "c1", Name: "open", Payload: json.RawMessage(`{"title":"first"}`)}))
+	acked, pushed := false, false
+	for !acked || !pushed {
+		m = read()
+		switch m.Op {
+		case WS_Ack:
+			acked = true
+			Equals(t, "c1", m.Ref, "should acknowledge the command")
+			Equals(t, 200, m.Status, "should carry the sync status")
+			Assert(t, strings.Contains(string(m.Payload), `"title":"first"`), "should carry the resulting event [ %s ]", m.Payload)
+		case WS_Event:
+			pushed = true
+			Equals(t, "s1", m.Subscription, "should push to the subscription")
+		}
+	}
+
+	Ok(t, conn.WriteJSON(&WebSocketMessage{Op: WS_Command, Ref: "c2", Name: "open", Payload: json.RawMessage(`{"title":"missing"}`)}))
+	for m = read(); m.Op != WS_Ack; m = read() {
+	}
+	Equals(t, 404, m.Status, "should map rejections as the http response would")
+}
+
+func Test_Should_close_once_the_token_is_no_longer_valid(t *testing.T) {
+	deps := &hubDependencies{testdeps.NewDependencies(), ioc.NewPublisherHub(nil)}
+	now := time.Now()
+	offset := int64(0)
+	deps.Mock_Time.Mock_Now = func() int64 { return now.UnixNano() + atomic.LoadInt64(&offset) }
+	deps.Mock_Crypto.Mock_DecodeToken = func(m *testdeps.Mock_Crypto, token []byte, mods ...j.TokenModifier) (*j.TokenDef, error) {
+		return j.Decode(token, j.RemoveConstraints(j.None_Algo))
+	}
+	s, err := NewServer(testProvider(deps))
+	Ok(t, err)
+	s.Define(WebSocket("ws", WebSocketConfig{Domains: []cqrs.Domain{ticketDomain}}))
+	test_server := httptest.NewServer(s.BuildRouter())
+	defer test_server.Close()
+	token := unsignedToken(`{"jid":"1","exp":` + strconv.FormatInt(now.Add(time.Hour).Unix(), 10) + `}`)
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(test_server.URL, "http")+"/ws", http.Header{"Authorization": {"Bearer " + token}})
+	Ok(t, err)
+	defer conn.Close()
+
+	Ok(t, conn.WriteJSON(&WebSocketMessage{Op: WS_Subscribe, Ref: "s1"}))
+	m := &WebSocketMessage{}
+	Ok(t, conn.ReadJSON(m))
+	Equals(t, 200, m.Status, "should subscribe with a valid token")
+	atomic.StoreInt64(&offset, int64(2*time.Hour))
+	Ok(t, conn.WriteJSON(&WebSocketMessage{Op: WS_Command, Ref: "c1", Name: "open", Payload: json.RawMessage(`{"title":"late"}`)}))
+	err = conn.ReadJSON(m)
+	Assert(t, websocket.IsCloseError(err, websocket.ClosePolicyViolation), "should close for the expired token [ %s ]", err)
+	events, err := deps.EventStore().GetDomainEvents(ticketDomain.Id(), 0, 0)
+	Ok(t, err)
+	Equals(t, 0, len(events), "should not run the command")
+}
+
+func Test_Should_run_commands_through_the_route_middleware(t *testing.T) {
+	s, err := NewServer(testProvider(testdeps.NewDependencies()))
+	Ok(t, err)
+	s.Define(Sub("api",
+		Middleware("limit", NewRateLimit(RateLimit{Name: "ws", Rate: 0.001, Burst: 2})),
+		WebSocket("ws", WebSocketConfig{Domains: []cqrs.Domain{ticketDomain}, Anonymous: true}),
+	))
+	test_server := httptest.NewServer(s.BuildRouter())
+	defer test_server.Close()
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(test_server.URL, "http")+"/api/ws", nil)
+	Ok(t, err)
+	defer conn.Close()
+	command := func(ref string) *WebSocketMessage {
+		Ok(t, conn.WriteJSON(&WebSocketMessage{Op: WS_Command, Ref: ref, Name: "open", Payload: json.RawMessage(`{"title":"limited"}`)}))
+		m := &WebSocketMessage{}
+		Ok(t, conn.ReadJSON(m))
+		return m
+	}
+
+	Equals(t, 200, command("c1").Status, "should allow the command left in the burst")
+	Equals(t, 429, command("c2").Status, "should limit commands as the http route would")
+	Ok(t, conn.WriteJSON(&WebSocketMessage{Op: "publish", Ref: "p1"}))
+	m := &WebSocketMessage{}
+	Ok(t, conn.ReadJSON(m))
+	Assert(t, strings.Contains(string(m.Payload), "40160"), "should answer an unknown op with it's own code [ %s ]", m.Payload)
+}
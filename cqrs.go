@@ -1,6 +1,7 @@
 package cqrs
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -22,6 +23,11 @@ var (
 	// * Domain is semantically equal to Aggregate Type
 	ErrInvalidDomain = errors.New("invalid domain identifier")
 
+	// ErrInvalidDomainUri is used to inform a consumer when they've
+	// provided a domain uri that can't be parsed into a namespace, name
+	// and version
+	ErrInvalidDomainUri = errors.New("invalid domain uri")
+
 	// ErrInvalidAggregateId is used to inform a consumer when they've
 	// provided an aggregate id that is not available due to either
 	// overlap with an existing aggregate or domain specific command
@@ -114,23 +120,35 @@ type DomainMessagesFunc func() (Domain, map[MessageType]func() MessageDefiner)
 type Domain interface {
 	Name() string
 	Version() string
+	Namespace() string
+	Identity() DomainIdentity
 	Uri() string
 	SourceId() int64
 	Id() int32
 	Aggregate() AggregateState
+	// Message creates an empty payload and panics if the type isn't registered
 	Message(message_type MessageType) MessageDefiner
+	// LookupMessage creates an empty payload or returns ErrUnknownMessage
+	LookupMessage(message_type MessageType) (MessageDefiner, error)
 	Messages(message_type ...MessageType) map[MessageType]func() MessageDefiner
 	Commands(message_type ...MessageType) map[MessageType]func() MessageDefiner
 	Events(message_type ...MessageType) map[MessageType]func() MessageDefiner
 	MessageType(message MessageDefiner) MessageType
 	MessageName(message MessageDefiner) string
-	Handler(deps interface{}, command Message) Message
+	// Handler returns the appended event, the error is a *HandlerError when
+	// the command couldn't be handled in which case any error event which was
+	// appended is still returned
+	Handler(deps interface{}, command Message) (Message, error)
+	// HandlerContext handles the command with it's dependencies bound to ctx
+	HandlerContext(ctx context.Context, deps interface{}, command Message) (Message, error)
 	Services(MessageType) map[string]EventHandler
 	// Config Functions
 	DefCommandHandler(factory func(CommandHandlerDef) CommandHandlerFunc) CommandHandler
 	DefEventHandler(message_type MessageType, service_key string, handler EventHandler) EventHandler
 	DefService(factory func(EventHandlerDef) EventHandlerFunc, subs ...map[MessageType]func() MessageDefiner) EventHandler
-	DefCommand(version uint8, id uint32, m MessageDefiner) MessageType
+	DefInterceptor(interceptor CommandInterceptor)
+	Interceptors() []CommandInterceptor
+	DefCommand(version uint8, id uint32, m MessageDefiner, handler ...func(CommandHandlerDef) CommandHandlerFunc) MessageType
 	DefEvent(version uint8, id uint32, m MessageDefiner) MessageType
 }
 
@@ -138,22 +156,43 @@ type DomainDefiner interface {
 	Domain() Domain
 }
 
-type CommandHandler func(interface{}, Message) Message
+type CommandHandler func(interface{}, Message) (Message, error)
 type CommandHandlerFactory func(interface{}, Domain, Message) CommandHandler
 type CommandHandlerFunc func(AggregateHeader, AggregateState, Message, MessageDefiner)
 
-type EventHandler func(interface{}, Message)
+// CommandInterceptor is created once per command handled by a domain and
+// returns the hooks to run around the command, the hooks may reject the
+// command by calling Error on the handler before it is hydrated
+type CommandInterceptor func(CommandHandlerDef) CommandHooks
+
+// CommandHooks are the optional stages of the command path an interceptor
+// can observe.  BeforeHydrate runs in registration order, AfterHandler and
+// AfterAppend in reverse so that interceptors nest.
+type CommandHooks struct {
+	BeforeHydrate func()
+	AfterHandler  func()
+	AfterAppend   func(Message)
+}
+
+type EventHandler func(interface{}, Message) error
 type EventHandlerFactory func(interface{}, Domain, Message) EventHandler
 type EventHandlerFunc func(Message, MessageDefiner)
 
 type CommandHandlerDef interface {
 	// Server actions
-	Exec(handler CommandHandlerFunc) Message
+	Exec(handler CommandHandlerFunc) (Message, error)
 	// Handler Actions
 	Publish(MessageDefiner, ...func(*MessageOptionsDef))
 	Error(string, ...interface{})
+	// Reject refuses the command with a code classifying the refusal such
+	// as forbidden or not found
+	Reject(int, string, ...interface{})
 	Assert(bool, string, ...interface{})
 	// Data access
+	Context() context.Context
+	Deps() interface{}
+	Domain() Domain
+	Header() AggregateHeader
 	State() AggregateState
 	Command() Message
 	CommandPayload() MessageDefiner
@@ -162,10 +201,11 @@ type CommandHandlerDef interface {
 }
 
 type EventHandlerDef interface {
+	Context() context.Context
 	Deps() interface{}
-	Publish(MessageDefiner, ...func(*MessageOptionsDef)) Message
-	Error(string, ...interface{}) Message
-	Exec(EventHandlerFunc)
+	Publish(MessageDefiner, ...func(*MessageOptionsDef)) (Message, error)
+	Error(string, ...interface{}) (Message, error)
+	Exec(EventHandlerFunc) error
 }
 
 type Service interface {
@@ -213,6 +253,7 @@ type Message interface {
 	GetOrigin() []AggregateHeader
 	GetMessageType() MessageType
 	GetData() []byte
+	GetTraceParent() string
 	Reference() *MessageData
 }
 
@@ -1,9 +1,13 @@
 package domains
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"github.com/xzeus/cqrs"
 	. "github.com/xzeus/cqrs/ioc"
+	"runtime/debug"
+	"time"
 )
 
 type commandHandlerDef struct {
@@ -17,39 +21,93 @@ type commandHandlerDef struct {
 	event_payload   cqrs.MessageDefiner
 	event_loader    func() ([]cqrs.Message, error)
 	event_append    func() (cqrs.Message, error)
+	hooks           []cqrs.CommandHooks
+	started         time.Time
+	span            Span
+	err             *cqrs.HandlerError // First failure, returned from Exec
+	cancelled       bool               // Caller went away so nothing is appended
 }
 
-var default_event_options = cqrs.NewMessageOptions(0, 1, int64(0))
+// ErrNoEventPublished is the cause of the handler fault recorded when a
+// handler returns without publishing or rejecting
+var ErrNoEventPublished = errors.New("event not published in handler")
 
-func NewCommandHandler(deps Dependencies, domain cqrs.Domain, command cqrs.Message) cqrs.CommandHandlerDef {
+func NewCommandHandler(deps Dependencies, domain cqrs.Domain, command cqrs.Message) (cqrs.CommandHandlerDef, error) {
+	command_payload, err := domain.LookupMessage(command.GetMessageType())
+	if err != nil {
+		return nil, err
+	}
 	h := &commandHandlerDef{
 		deps:            deps,
 		domain:          domain,
 		command:         command,
-		command_payload: domain.Message(command.GetMessageType()),
-		event_options:   default_event_options,
+		command_payload: command_payload,
+		event_options:   cqrs.NewMessageOptions(0, 1, int64(0)), // Each handler's own, publish modifies it
+		started:         time.Now(),
+	}
+	parent, _ := ParseTraceParent(command.GetTraceParent())
+	h.span = deps.Tracer().Start("command "+domain.Name()+"/"+domain.MessageName(h.command_payload), parent)
+	h.span.SetAttributes(Fields{
+		FieldDomain:      domain.Uri(),
+		FieldAggregateId: fmt.Sprintf("%X", uint64(command.GetId())),
+		FieldMessageType: fmt.Sprintf("%X", uint32(command.GetMessageType())),
+	})
+	interceptors := domain.Interceptors()
+	h.hooks = make([]cqrs.CommandHooks, len(interceptors))
+	for i, interceptor := range interceptors {
+		h.hooks[i] = interceptor(h)
 	}
 	if err := cqrs.Extract(h.command_payload, command); err != nil {
-		h.event_payload, h.event_options = deps.Exception().Error("Unable to extract command payload: [ %s ]", err)
-		return h // If extract fails return an error event when run
+		h.fail(cqrs.ErrDecodeFailure, err)
+	} // If extract fails return an error event when run
+	for _, hooks := range h.hooks {
+		if hooks.BeforeHydrate != nil {
+			hooks.BeforeHydrate()
+		}
 	}
+	if h.event_payload == nil { // Interceptors may have rejected the command
+		h.hydrate()
+	}
+	return h, nil
+}
+
+func (h *commandHandlerDef) hydrate() {
+	if err := h.Context().Err(); err != nil { // Caller has gone away
+		h.cancel(err)
+		return
+	}
+	start := time.Now()
+	deps := h.deps
+	command := h.command
 	var key string
 	if key = cqrs.ExtractKey(h.command_payload); key == cqrs.DEFAULT_KEY {
-		h.event_loader = func() ([]cqrs.Message, error) {
-			return deps.EventStore().GetAggregateEvents(h.domain.Id(), command.GetId(), 0)
+		h.event_loader = func() (events []cqrs.Message, err error) {
+			span := deps.Tracer().Start("eventstore.GetAggregateEvents", h.span.Context())
+			defer span.End()
+			events, err = deps.EventStore().GetAggregateEvents(h.domain.Id(), command.GetId(), 0)
+			span.SetError(err)
+			return
 		}
 	} else { // Key based message
 		k := []byte(key)
-		h.event_loader = func() ([]cqrs.Message, error) {
-			return deps.EventStore().GetKeyedAggregateEvents(h.domain.Id(), k, 0)
+		h.event_loader = func() (events []cqrs.Message, err error) {
+			span := deps.Tracer().Start("eventstore.GetKeyedAggregateEvents", h.span.Context())
+			defer span.End()
+			events, err = deps.EventStore().GetKeyedAggregateEvents(h.domain.Id(), k, 0)
+			span.SetError(err)
+			return
 		}
 	}
 	var events []cqrs.Message
 	var err error
 	if events, err = h.event_loader(); err != nil {
 		if events, err = h.event_loader(); err != nil { // Single retry
-			h.Error("Error loading aggregate events [ %s ]", err)
-			return h
+			if ctx_err := h.Context().Err(); ctx_err != nil {
+				h.cancel(ctx_err)
+				return
+			}
+			h.fail(cqrs.ErrStoreUnavailable, err)
+			return
 		}
 	} // Event load success, hydrate the aggregate
 	version := int32(len(events)) + 1
@@ -63,62 +121,175 @@ func NewCommandHandler(deps Dependencies, domain cqrs.Domain, command cqrs.Messa
 	state := h.domain.Aggregate().Init()
 	var payload cqrs.MessageDefiner
 	for i, event := range events {
-		payload = h.domain.Message(event.GetMessageType())
+		if payload, err = h.domain.LookupMessage(event.GetMessageType()); err != nil {
+			h.fail(cqrs.ErrUnknownMessage, fmt.Errorf("event [ %d ] has type [ %X ]", i, uint32(event.GetMessageType())))
+			return
+		}
 		if err := cqrs.Extract(payload, event); err != nil {
-			h.Error("Error extracting event[ %d ] [ %#v ]", i, event)
-			return h
+			h.fail(cqrs.ErrDecodeFailure, fmt.Errorf("event [ %d ]: %s", i, err))
+			return
 		} // Event payload extracted, apply it to the state
 		state.Handle(payload)
 	}
 	h.state = state
 	h.header = cqrs.NewAggregateHeader(h.domain.SourceId(), h.domain.Id(), h.event_options.Id(), int32(len(events)))
-	return h
+	labels := h.labels()
+	h.deps.Metrics().Observe(MetricHydrateSeconds, time.Since(start).Seconds(), labels)
+	h.deps.Metrics().Observe(MetricEventsReplayed, float64(len(events)), labels)
 }
 
-func (h *commandHandlerDef) Exec(handler cqrs.CommandHandlerFunc) (result cqrs.Message) {
+func (h *commandHandlerDef) Exec(handler cqrs.CommandHandlerFunc) (result cqrs.Message, err error) {
 	defer func() { // Best effort to commit result
-		var err error
-		if h.event_payload == nil {
-			h.Error("Error event not published in handler", err)
-		}
-		var key string
-		if key = cqrs.ExtractKey(h.event_payload); key == cqrs.DEFAULT_KEY {
-			h.event_append = func() (cqrs.Message, error) {
-				id := h.event_options.Id()
-				ver := h.event_options.Version()
-
-				h.deps.Logger().Infof("CMD \033[0;106;90m %s/\033[1;30m%s \033[0;49;37m  [ %X v:%d ]\033[0;49;39m", h.domain.Name(), h.domain.MessageName(h.command_payload), uint64(id), ver)
-				for i, o := range h.command.GetOrigin() {
-					oid := o.GetId()
-					ov := o.GetVersion()
-					od := Meta().Domains[o.GetDomainId()].Domain
-					oname := od.Name()
-					h.deps.Logger().Infof("\t\033[90m ORIG [ %d ] [ %s - %X v:%d ]\033[0;49;39m", i, oname, uint64(oid), ov)
-				}
-				return h.deps.EventStore().AppendEvent(id, ver, h.command.GetOrigin(), h.event_payload)
-			}
-		} else { // Key based message
-			k := []byte(key)
-			h.event_append = func() (cqrs.Message, error) {
-				return h.deps.EventStore().AppendKeyedEvent(k, h.command.GetOrigin(), h.event_payload)
+		if r := recover(); r != nil { // Record the fault in place of any published event
+			h.log().With(Fields{FieldError: fmt.Sprintf("%v", r), "stack": string(debug.Stack())}).Errorf("command handler panicked")
+			h.event_payload = nil
+			h.fail(cqrs.ErrHandlerFault, fmt.Errorf("%v", r))
+		}
+		for i := len(h.hooks) - 1; i >= 0; i-- {
+			if h.hooks[i].AfterHandler != nil {
+				h.hooks[i].AfterHandler()
 			}
 		}
-		if result, err = h.event_append(); err != nil {
-			h.deps.Logger().Infof("Trigger error for [\n%s\n]", h.EventPayload())
-			h.ForceError("Error appending event [ %s ]", err)
-			if result, err = h.event_append(); err != nil { // Try to append the failure message
-				panic(err) // Multiple append errors
+		if h.cancelled { // Nothing is recorded for a caller that has gone away
+			result = nil
+		} else {
+			result = h.commit()
+		}
+		if h.err != nil {
+			err = h.err
+			h.span.SetError(err)
+		}
+		labels := h.labels()
+		h.deps.Metrics().Observe(MetricCommandSeconds, time.Since(h.started).Seconds(), labels)
+		switch {
+		case h.cancelled:
+			labels["result"] = "cancelled"
+		case result == nil:
+			labels["result"] = "failed"
+		case result.GetDomainId() == h.domain.Id():
+			labels["result"] = "accepted"
+		default: // Error events are published to another domain
+			labels["result"] = "rejected"
+		}
+		h.deps.Metrics().Add(MetricCommands, 1, labels)
+		if result == nil { // Nothing was recorded so there's nothing to publish
+			h.span.End()
+			return
+		}
+		for i := len(h.hooks) - 1; i >= 0; i-- {
+			if h.hooks[i].AfterAppend != nil {
+				h.hooks[i].AfterAppend(result)
 			}
-		} // Exec publisher if defined
-		h.deps.Publisher().Publish(result)
+		}
+		h.span.SetAttributes(Fields{FieldVersion: result.GetVersion(), "result": labels["result"]})
+		h.span.End() // Exec publisher if defined
+		h.deps.Publisher().Publish(cqrs.WithTraceParent(result, h.span.Context().TraceParent()))
 	}() // Check for errors from constructor
-	if h.event_payload != nil { // Enforce single publish maxim
+	if h.cancelled || h.event_payload != nil { // Enforce single publish maxim
 		return
 	}
 	handler(h.header, h.state, h.command, h.command_payload)
 	return
 }
 
+// commit appends the published event, or the fault recorded in it's place
+// when there was none or the append failed
+func (h *commandHandlerDef) commit() cqrs.Message {
+	if h.event_payload == nil {
+		h.fail(cqrs.ErrHandlerFault, ErrNoEventPublished)
+	}
+	h.bindAppend()
+	result, append_err := h.append()
+	if append_err != nil {
+		h.log().With(Fields{FieldError: append_err}).Errorf("unable to append [ %s ]", h.domain.MessageName(h.event_payload))
+		h.event_payload = nil
+		if err := h.Context().Err(); err != nil { // Gone away while appending
+			h.cancel(err)
+			return nil
+		}
+		h.fail(appendErrorKind(append_err), append_err)
+		// The fault isn't keyed like the event it replaces
+		h.bindAppend()
+		if result, append_err = h.append(); append_err != nil { // Try to append the failure message
+			h.log().With(Fields{FieldError: append_err}).Errorf("unable to append failure")
+			result = nil
+		}
+	}
+	return result
+}
+
+// bindAppend sets the append for the event payload, by key when it's keyed
+// or by the event options' id and version otherwise
+func (h *commandHandlerDef) bindAppend() {
+	var key string
+	if key = cqrs.ExtractKey(h.event_payload); key == cqrs.DEFAULT_KEY {
+		h.event_append = func() (cqrs.Message, error) {
+			id := h.event_options.Id()
+			ver := h.event_options.Version()
+
+			log := h.log().With(Fields{FieldAggregateId: fmt.Sprintf("%X", uint64(id)), FieldVersion: ver})
+			log.Debugf("command [ %s ] appending [ %s ]", h.domain.MessageName(h.command_payload), h.domain.MessageName(h.event_payload))
+			for i, o := range h.command.GetOrigin() {
+				oname := fmt.Sprintf("%X", uint32(o.GetDomainId()))
+				if od, found := Meta().Domains[o.GetDomainId()]; found {
+					oname = od.Domain.Name()
+				}
+				log.Debugf("origin [ %d ] [ %s - %X v:%d ]", i, oname, uint64(o.GetId()), o.GetVersion())
+			}
+			return h.deps.EventStore().AppendEvent(id, ver, h.command.GetOrigin(), h.event_payload)
+		}
+	} else { // Key based message
+		k := []byte(key)
+		h.event_append = func() (cqrs.Message, error) {
+			return h.deps.EventStore().AppendKeyedEvent(k, h.command.GetOrigin(), h.event_payload)
+		}
+	}
+}
+
+// fail keeps the first failure so that the cause isn't masked by the error
+// events that follow it and publishes it's fault event
+func (h *commandHandlerDef) fail(kind error, err error) {
+	if h.err == nil {
+		h.err = cqrs.NewHandlerError(kind, h.domain.Uri(), h.command.GetMessageType(), err)
+	}
+	if h.event_payload != nil { // Enforce single publish maxim
+		return
+	}
+	h.event_payload, h.event_options = h.deps.Exception().Fault(h.command, h.err)
+	h.log().With(Fields{FieldError: h.err}).Warnf("command failed")
+}
+
+// cancel records that the caller went away, unlike fail no fault event is
+// appended on it's behalf
+func (h *commandHandlerDef) cancel(err error) {
+	if h.err == nil {
+		h.err = cqrs.NewHandlerError(cqrs.ErrCancelled, h.domain.Uri(), h.command.GetMessageType(), err)
+	}
+	h.cancelled = true
+	h.event_payload = nil
+	h.log().With(Fields{FieldError: err}).Debugf("command cancelled")
+}
+
+// appendErrorKind distinguishes version conflicts from store failures
+func appendErrorKind(err error) error {
+	switch {
+	case errors.Is(err, ErrStaleEventVersion), errors.Is(err, ErrAggregateIdInUse), errors.Is(err, ErrAggregateKeyCollision):
+		return cqrs.ErrConflict
+	}
+	return cqrs.ErrStoreUnavailable
+}
+
+// append runs the event append within a span and records it's duration
+func (h *commandHandlerDef) append() (result cqrs.Message, err error) {
+	start := time.Now()
+	span := h.deps.Tracer().Start("eventstore.AppendEvent", h.span.Context())
+	result, err = h.event_append()
+	span.SetError(err)
+	span.End()
+	h.deps.Metrics().Observe(MetricAppendSeconds, time.Since(start).Seconds(), Labels{"domain": h.domain.Identity().String()})
+	return
+}
+
 func (h *commandHandlerDef) Publish(event_payload cqrs.MessageDefiner, options ...func(*cqrs.MessageOptionsDef)) {
 	if h.event_payload != nil { // Enforce single publish maxim
 		return
@@ -129,26 +300,68 @@ func (h *commandHandlerDef) Publish(event_payload cqrs.MessageDefiner, options .
 	h.event_payload = event_payload
 }
 
-func (h *commandHandlerDef) Error(message string, args ...interface{}) {
+// publishWith publishes the payload with options replacing the handler's
+// own rather than modifying them
+func (h *commandHandlerDef) publishWith(event_payload cqrs.MessageDefiner, options *cqrs.MessageOptionsDef) {
 	if h.event_payload != nil { // Enforce single publish maxim
 		return
 	}
-	h.event_payload, h.event_options = h.deps.Exception().Error(message, args...)
-	h.deps.Logger().Infof("\n\n***\tCalled error: [\n%#v\n]", h.event_payload, h.event_options)
+	h.event_payload, h.event_options = event_payload, options
 }
 
-func (h *commandHandlerDef) ForceError(message string, args ...interface{}) {
-	h.event_payload, h.event_options = h.deps.Exception().Error(message, args...)
-	h.deps.Logger().Infof("\n\n***\tCalled force error: [\n%#v\n]", h.event_payload, h.event_options)
+func (h *commandHandlerDef) Error(message string, args ...interface{}) {
+	h.Reject(CodeInvalid, message, args...)
+}
+
+func (h *commandHandlerDef) Reject(code int, message string, args ...interface{}) {
+	if h.event_payload != nil { // Enforce single publish maxim
+		return
+	}
+	h.event_payload, h.event_options = h.deps.Exception().Reject(h.command, code, message, args...)
+	h.log().With(Fields{"code": code}).Warnf("command rejected: "+message, args...)
 }
 
 func (h *commandHandlerDef) Assert(predicate bool, message string, args ...interface{}) {
 	if predicate || h.event_payload != nil { // Enforce single publish maxim
 		return
 	} // Assertion failed and no prior event
-	message = fmt.Sprintf(message, args)
-	h.deps.Logger().Infof("Assert failed [ %s ]", message)
-	h.Error("Assert failed: " + message)
+	message = fmt.Sprintf(message, args...)
+	h.Error("Assert failed: %s", message)
+}
+
+// log provides a logger with the command's domain, aggregate and type fields
+func (h *commandHandlerDef) log() Logger {
+	return h.deps.Logger().With(Fields{
+		FieldDomain:      h.domain.Uri(),
+		FieldAggregateId: fmt.Sprintf("%X", uint64(h.command.GetId())),
+		FieldVersion:     h.command.GetVersion(),
+		FieldMessageType: fmt.Sprintf("%X", uint32(h.command.GetMessageType())),
+		FieldMessage:     h.domain.MessageName(h.command_payload),
+	})
+}
+
+// labels identify the command in metrics
+func (h *commandHandlerDef) labels() Labels {
+	return Labels{
+		"domain":  h.domain.Identity().String(),
+		"command": h.domain.MessageName(h.command_payload),
+	}
+}
+
+func (h *commandHandlerDef) Context() context.Context {
+	return Context(h.deps)
+}
+
+func (h *commandHandlerDef) Deps() interface{} {
+	return h.deps
+}
+
+func (h *commandHandlerDef) Domain() cqrs.Domain {
+	return h.domain
+}
+
+func (h *commandHandlerDef) Header() cqrs.AggregateHeader {
+	return h.header
 }
 
 func (h *commandHandlerDef) State() cqrs.AggregateState {
@@ -1,18 +1,22 @@
 package domains
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"github.com/vizidrix/crypto"
 	"github.com/xzeus/cqrs"
 	"github.com/xzeus/cqrs/ioc"
-	"log"
 	"reflect"
 	"runtime/debug"
+	"sort"
+	"time"
 )
 
 var meta = SourceMetadata{
-	SourceUri: "",
-	Domains:   make(map[int32]*DomainMetadata),
+	SourceUri:    "",
+	Domains:      make(map[int32]*DomainMetadata),
+	interceptors: []cqrs.CommandInterceptor{Validation},
 }
 
 func Meta() SourceMetadata {
@@ -21,19 +25,27 @@ func Meta() SourceMetadata {
 
 type DomainImpl struct {
 	uri             string
+	identity        cqrs.DomainIdentity
 	id              int32
 	domain          cqrs.Domain
 	command_handler cqrs.CommandHandler
-	event_handlers  map[cqrs.MessageType]map[string]cqrs.EventHandler
-	factory         func() cqrs.AggregateState
-	factory_map     map[cqrs.MessageType]func() cqrs.MessageDefiner
-	type_map        map[string]cqrs.MessageType
+	// handler_factory is the single domain wide handler used for any command
+	// without a handler of it's own in command_factories
+	handler_factory   func(cqrs.CommandHandlerDef) cqrs.CommandHandlerFunc
+	command_factories map[cqrs.MessageType]func(cqrs.CommandHandlerDef) cqrs.CommandHandlerFunc
+	interceptors      []cqrs.CommandInterceptor
+	event_handlers    map[cqrs.MessageType]map[string]cqrs.EventHandler
+	factory           func() cqrs.AggregateState
+	factory_map       map[cqrs.MessageType]func() cqrs.MessageDefiner
+	type_map          map[reflect.Type]cqrs.MessageType
+	name_map          map[string]reflect.Type
 }
 
 type SourceMetadata struct {
-	SourceUri string
-	SourceId  int64
-	Domains   map[int32]*DomainMetadata
+	SourceUri    string
+	SourceId     int64
+	Domains      map[int32]*DomainMetadata
+	interceptors []cqrs.CommandInterceptor
 }
 
 func (m SourceMetadata) SetSourceUri(uri string) {
@@ -52,10 +64,72 @@ func (m SourceMetadata) GetSourceId() int64 {
 	return meta.SourceId
 }
 
+// DefInterceptor adds an interceptor which runs around every command in
+// every domain, ahead of any defined on the domain itself
+func (m SourceMetadata) DefInterceptor(interceptor cqrs.CommandInterceptor) {
+	meta.interceptors = append(meta.interceptors, interceptor)
+}
+
+// Interceptors returns the interceptors applied to every domain
+func (m SourceMetadata) Interceptors() []cqrs.CommandInterceptor {
+	return meta.interceptors
+}
+
+// Validate reports the commands that have no handler so that startup can fail
+// instead of the first request, only the given domains are checked unless
+// none are given in which case every defined domain is
+func (m SourceMetadata) Validate(domains ...cqrs.Domain) error {
+	checked := make(map[int32]bool, len(domains))
+	for _, d := range domains {
+		checked[d.Id()] = true
+	}
+	conflicts := make([]string, 0)
+	for id, d := range meta.Domains {
+		if len(checked) > 0 && !checked[id] {
+			continue
+		}
+		if impl, ok := d.Domain.(*DomainImpl); ok {
+			for _, t := range impl.Unhandled() {
+				conflicts = append(conflicts, fmt.Sprintf("[ %s ] command [ %s ] has no handler", d.Uri, d.Commands[t].Name))
+			}
+		}
+	}
+	if len(conflicts) == 0 {
+		return nil
+	}
+	sort.Strings(conflicts)
+	return &RegistrationError{Uri: meta.SourceUri, Conflicts: conflicts}
+}
+
+// Versions returns every defined version of the domain identified by key
+// [ namespace / name ] ordered from oldest to newest
+func (m SourceMetadata) Versions(key string) []*DomainMetadata {
+	r := make([]*DomainMetadata, 0)
+	for _, d := range meta.Domains {
+		if d.Identity.Key() == key {
+			r = append(r, d)
+		}
+	}
+	sort.Slice(r, func(i, j int) bool {
+		return r[i].Identity.Version.Less(r[j].Identity.Version)
+	})
+	return r
+}
+
+// Latest returns the highest defined version of the domain identified by
+// key [ namespace / name ]
+func (m SourceMetadata) Latest(key string) (*DomainMetadata, bool) {
+	if v := m.Versions(key); len(v) > 0 {
+		return v[len(v)-1], true
+	}
+	return nil, false
+}
+
 // TODO: Add info about what services handle this domains's events in the current context
 type DomainMetadata struct {
 	Domain   cqrs.Domain
 	Uri      string
+	Identity cqrs.DomainIdentity
 	Commands map[cqrs.MessageType]*MessageMetadata
 	Events   map[cqrs.MessageType]*MessageMetadata
 }
@@ -65,46 +139,134 @@ type MessageMetadata struct {
 	Factory func() cqrs.MessageDefiner
 }
 
-var noop_command_handler = func(interface{}, cqrs.Message) cqrs.Message {
-	panic("command handler not defined")
-}
+// ErrNoCommandHandler is the cause of the handler fault returned for commands
+// without a handler
+var ErrNoCommandHandler = errors.New("command handler not defined")
+
+// ErrInvalidDependencies is the cause of the handler fault returned when the
+// dependencies passed to a handler don't implement ioc.Dependencies
+var ErrInvalidDependencies = errors.New("dependencies don't implement ioc.Dependencies")
 
 func NewDomain(domain cqrs.DomainDefiner, uri string, a cqrs.AggregateState, configs ...func(cqrs.Domain)) cqrs.Domain {
-	id := crypto.New32a([]byte(uri))
 	v := reflect.ValueOf(a).Elem().Type()
 	f := func() cqrs.AggregateState {
 		return reflect.New(v).Interface().(cqrs.AggregateState)
 	}
+	return newDomainImpl(domain, uri, f)
+}
+
+func newDomainImpl(domain cqrs.DomainDefiner, uri string, f func() cqrs.AggregateState) *DomainImpl {
+	id := crypto.New32a([]byte(uri))
+	identity, err := cqrs.ParseDomainUri(uri)
+	if err != nil {
+		panic(NewRegistrationError(uri, "%s", err))
+	}
+	if existing, found := meta.Domains[id]; found {
+		if existing.Uri == uri {
+			panic(NewRegistrationError(uri, "domain already defined"))
+		}
+		panic(NewRegistrationError(uri, "domain id [ %X ] collides with [ %s ]", uint32(id), existing.Uri))
+	}
+	for _, existing := range meta.Versions(identity.Key()) {
+		if existing.Identity.Version == identity.Version {
+			panic(NewRegistrationError(uri, "domain version [ %s ] already defined by [ %s ]", identity, existing.Uri))
+		}
+	}
 
 	domain_impl := &DomainImpl{
-		uri:             uri,
-		id:              id,
-		domain:          domain.Domain(),
-		command_handler: noop_command_handler,
-		event_handlers:  make(map[cqrs.MessageType]map[string]cqrs.EventHandler),
-		factory:         f,
-		factory_map:     make(map[cqrs.MessageType]func() cqrs.MessageDefiner),
-		type_map:        make(map[string]cqrs.MessageType),
+		uri:               uri,
+		identity:          identity,
+		id:                id,
+		domain:            domain.Domain(),
+		command_factories: make(map[cqrs.MessageType]func(cqrs.CommandHandlerDef) cqrs.CommandHandlerFunc),
+		event_handlers:    make(map[cqrs.MessageType]map[string]cqrs.EventHandler),
+		factory:           f,
+		factory_map:       make(map[cqrs.MessageType]func() cqrs.MessageDefiner),
+		type_map:          make(map[reflect.Type]cqrs.MessageType),
+		name_map:          make(map[string]reflect.Type),
 	}
 
 	meta.Domains[id] = &DomainMetadata{
 		Domain:   domain_impl,
 		Uri:      uri,
+		Identity: identity,
 		Commands: map[cqrs.MessageType]*MessageMetadata{},
 		Events:   map[cqrs.MessageType]*MessageMetadata{},
 	}
 
+	domain_impl.command_handler = domain_impl.dispatch
 	return domain_impl
 }
 
+// DefCommandHandler binds a single handler for every command in the domain
+// that wasn't defined with a handler of it's own
 func (s *DomainImpl) DefCommandHandler(w func(cqrs.CommandHandlerDef) cqrs.CommandHandlerFunc) cqrs.CommandHandler {
-	s.command_handler = func(deps interface{}, c cqrs.Message) cqrs.Message {
-		h := NewCommandHandler(deps.(ioc.Dependencies), s, c)
-		return h.Exec(w(h))
-	}
+	s.handler_factory = w
 	return s.command_handler
 }
 
+func (s *DomainImpl) defCommandHandler(t cqrs.MessageType, w func(cqrs.CommandHandlerDef) cqrs.CommandHandlerFunc) {
+	if _, found := s.command_factories[t]; found {
+		panic(NewRegistrationError(s.uri, "command [ %X ] already has a handler", uint32(t)))
+	}
+	s.command_factories[t] = w
+}
+
+// dispatch routes the command to it's own handler by message type and falls
+// back to the domain wide handler
+func (s *DomainImpl) dispatch(deps interface{}, c cqrs.Message) (cqrs.Message, error) {
+	t := c.GetMessageType()
+	if _, found := s.factory_map[t]; !found || !t.IsCommand() {
+		return nil, cqrs.NewHandlerError(cqrs.ErrUnknownMessage, s.uri, t, nil)
+	}
+	w, found := s.command_factories[t]
+	if !found {
+		w = s.handler_factory
+	}
+	if w == nil {
+		return nil, cqrs.NewHandlerError(cqrs.ErrHandlerFault, s.uri, t, ErrNoCommandHandler)
+	}
+	d, ok := deps.(ioc.Dependencies)
+	if !ok {
+		return nil, cqrs.NewHandlerError(cqrs.ErrHandlerFault, s.uri, t, ErrInvalidDependencies)
+	}
+	h, err := NewCommandHandler(d, s, c)
+	if err != nil {
+		return nil, err
+	}
+	return h.Exec(w(h))
+}
+
+// Unhandled lists the commands which have neither a handler of their own
+// nor a domain wide handler to fall back to
+func (s *DomainImpl) Unhandled() []cqrs.MessageType {
+	r := make([]cqrs.MessageType, 0)
+	if s.handler_factory != nil {
+		return r
+	}
+	for t, _ := range s.Commands() {
+		if _, found := s.command_factories[t]; !found {
+			r = append(r, t)
+		}
+	}
+	sort.Slice(r, func(i, j int) bool { return uint32(r[i]) < uint32(r[j]) })
+	return r
+}
+
+// DefInterceptor adds an interceptor which runs around every command handled
+// by this domain
+func (s *DomainImpl) DefInterceptor(interceptor cqrs.CommandInterceptor) {
+	s.interceptors = append(s.interceptors, interceptor)
+}
+
+// Interceptors returns the global interceptors followed by the domain's own
+func (s *DomainImpl) Interceptors() []cqrs.CommandInterceptor {
+	global := Meta().Interceptors()
+	r := make([]cqrs.CommandInterceptor, 0, len(global)+len(s.interceptors))
+	r = append(r, global...)
+	return append(r, s.interceptors...)
+}
+
 func (s *DomainImpl) Services(message_type cqrs.MessageType) map[string]cqrs.EventHandler {
 	return s.event_handlers[message_type]
 }
@@ -121,18 +283,47 @@ func (s *DomainImpl) DefEventHandler(message_type cqrs.MessageType, service_key
 }
 
 func (s *DomainImpl) DefService(f func(cqrs.EventHandlerDef) cqrs.EventHandlerFunc, subs ...map[cqrs.MessageType]func() cqrs.MessageDefiner) cqrs.EventHandler {
-	defer func() {
-		if recoverErr := recover(); recoverErr != nil {
-			log.Printf("\n\n\n***\n***\n***\n\nRecovered in [ %s ]\n\n\n\n", "Domain/DefService")
-			log.Printf("\n\n\n[ %v ]\n\n\n\n", recoverErr)
-			log.Printf("\n\n\n%s\n\n\n***\n***\n***\n\n\n", debug.Stack())
-
-		} // Attempt to write server fault failed
-	}()
 	var eh cqrs.EventHandler
-	eh = func(deps interface{}, event cqrs.Message) {
-		handler := NewEventHandler(deps.(ioc.Dependencies), s, event)
-		handler.Exec(f(handler))
+	eh = func(deps interface{}, event cqrs.Message) (err error) {
+		d, ok := deps.(ioc.Dependencies)
+		if !ok {
+			return cqrs.NewHandlerError(cqrs.ErrHandlerFault, s.Uri(), event.GetMessageType(), ErrInvalidDependencies)
+		}
+		defer func() {
+			if recoverErr := recover(); recoverErr != nil {
+				err = cqrs.NewHandlerError(cqrs.ErrHandlerFault, s.Uri(), event.GetMessageType(), fmt.Errorf("%v", recoverErr))
+				d.Logger().With(ioc.Fields{
+					ioc.FieldServiceKey: s.Uri(),
+					ioc.FieldError:      fmt.Sprintf("%v", recoverErr),
+					ioc.FieldStack:      string(debug.Stack()),
+				}).Errorf("recovered from panic in service")
+			}
+		}()
+		d.Logger().With(ioc.Fields{
+			ioc.FieldServiceKey:  s.Uri(),
+			ioc.FieldAggregateId: fmt.Sprintf("%X", uint64(event.GetId())),
+			ioc.FieldVersion:     event.GetVersion(),
+			ioc.FieldMessageType: fmt.Sprintf("%X", uint32(event.GetMessageType())),
+		}).Debugf("handling event")
+		start := time.Now()
+		labels := ioc.Labels{"service": s.Identity().String(), "event": fmt.Sprintf("%X", uint32(event.GetMessageType()))}
+		if ts := event.GetTimestamp(); ts != cqrs.NoAssignedTime { // Unix nanoseconds as provided by ioc.Time
+			d.Metrics().Observe(ioc.MetricEventHandlerLag, float64(d.Time().Now()-ts)/float64(time.Second), labels)
+		}
+		handler, err := NewEventHandler(d, s, event)
+		if err == nil {
+			err = handler.Exec(f(handler))
+		}
+		if err != nil {
+			d.Logger().With(ioc.Fields{
+				ioc.FieldServiceKey:  s.Uri(),
+				ioc.FieldAggregateId: fmt.Sprintf("%X", uint64(event.GetId())),
+				ioc.FieldError:       err,
+			}).Errorf("event handler failed")
+		}
+		d.Metrics().Observe(ioc.MetricEventHandlerSeconds, time.Since(start).Seconds(), labels)
+		d.Metrics().Add(ioc.MetricEventHandlers, 1, labels)
+		return err
 	}
 	for _, sub := range subs { // For each sub in the list
 		for t, f_msg := range sub { // Link to each message type
@@ -144,44 +335,104 @@ func (s *DomainImpl) DefService(f func(cqrs.EventHandlerDef) cqrs.EventHandlerFu
 	return eh
 }
 
-func (s *DomainImpl) def(t cqrs.MessageType, m cqrs.MessageDefiner) {
-	v := reflect.ValueOf(m).Elem().Type()
-	f := func() cqrs.MessageDefiner {
-		m := reflect.New(v).Interface().(cqrs.MessageDefiner)
-		return m
+func (s *DomainImpl) def(v uint8, id uint32, t cqrs.MessageType, m cqrs.MessageDefiner) {
+	mt := reflect.ValueOf(m).Elem().Type()
+	s.defType(v, id, t, mt, m, func() cqrs.MessageDefiner {
+		return reflect.New(mt).Interface().(cqrs.MessageDefiner)
+	})
+}
+
+func (s *DomainImpl) defFactory(v uint8, id uint32, t cqrs.MessageType, m cqrs.MessageDefiner, f func() cqrs.MessageDefiner) {
+	s.defType(v, id, t, reflect.ValueOf(m).Elem().Type(), m, f)
+}
+
+func (s *DomainImpl) defType(v uint8, id uint32, t cqrs.MessageType, mt reflect.Type, m cqrs.MessageDefiner, f func() cqrs.MessageDefiner) {
+	if conflicts := s.conflicts(v, id, t, mt, m); len(conflicts) > 0 {
+		panic(&RegistrationError{Uri: s.uri, Conflicts: conflicts})
+	}
+	if t.IsCommand() { // Malformed validate tags fail at startup
+		if err := cqrs.CompileValidation(m); err != nil {
+			panic(NewRegistrationError(s.uri, "command [ %s ] %s", typeName(mt), err))
+		}
 	}
 	mm := &MessageMetadata{
-		Name:    v.Name(),
+		Name:    mt.Name(),
 		Factory: f,
 	}
-	l := meta.Domains[m.Domain().Id()]
+	l := meta.Domains[s.id]
 	if t.IsCommand() {
 		l.Commands[t] = mm
 	} else {
 		l.Events[t] = mm
 	}
 	s.factory_map[t] = f
-	s.type_map[mm.Name] = t
+	s.type_map[mt] = t
+	s.name_map[mm.Name] = mt
+}
+
+// conflicts lists every reason the message can't be registered as the
+// provided type without overwriting or shadowing an existing definition
+func (s *DomainImpl) conflicts(v uint8, id uint32, t cqrs.MessageType, mt reflect.Type, m cqrs.MessageDefiner) (r []string) {
+	kind := "event"
+	if t.IsCommand() {
+		kind = "command"
+	}
+	if v > 0x7F || id > 0xFFFFFF { // Would be masked into another type's bits
+		r = append(r, fmt.Sprintf("%s [ %s ] version [ %d ] or id [ %d ] out of range", kind, typeName(mt), v, id))
+	}
+	if d := m.Domain(); d == nil || d.Id() != s.id {
+		r = append(r, fmt.Sprintf("%s [ %s ] does not belong to this domain", kind, typeName(mt)))
+	}
+	if f, found := s.factory_map[t]; found {
+		r = append(r, fmt.Sprintf("%s type [ %X ] for [ %s ] already defined by [ %s ]", kind, uint32(t), typeName(mt), typeName(reflect.TypeOf(f()).Elem())))
+	}
+	if existing, found := s.type_map[mt]; found {
+		if existing.IsCommand() != t.IsCommand() {
+			r = append(r, fmt.Sprintf("%s [ %s ] already defined as [ %X ] with mismatched command/event bit", kind, typeName(mt), uint32(existing)))
+		} else {
+			r = append(r, fmt.Sprintf("%s [ %s ] already defined as [ %X ]", kind, typeName(mt), uint32(existing)))
+		}
+	}
+	if existing, found := s.name_map[mt.Name()]; found && existing != mt {
+		r = append(r, fmt.Sprintf("%s name [ %s ] from [ %s ] clashes with [ %s ]", kind, mt.Name(), mt.PkgPath(), existing.PkgPath()))
+	}
+	return
 }
 
-func (s *DomainImpl) DefCommand(v uint8, id uint32, m cqrs.MessageDefiner) cqrs.MessageType {
+// DefCommand defines the command and optionally binds a handler used only
+// for this command type
+func (s *DomainImpl) DefCommand(v uint8, id uint32, m cqrs.MessageDefiner, handler ...func(cqrs.CommandHandlerDef) cqrs.CommandHandlerFunc) cqrs.MessageType {
 	t := cqrs.MakeVersionedCommandType(v, id)
-	s.def(t, m)
+	if len(handler) > 1 { // Before registering so the domain is left untouched
+		panic(NewRegistrationError(s.uri, "command [ %s ] defined with %d handlers", typeName(reflect.TypeOf(m).Elem()), len(handler)))
+	}
+	s.def(v, id, t, m)
+	for _, w := range handler {
+		s.defCommandHandler(t, w)
+	}
 	return t
 }
 
 func (s *DomainImpl) DefEvent(v uint8, id uint32, m cqrs.MessageDefiner) cqrs.MessageType {
 	t := cqrs.MakeVersionedEventType(v, id)
-	s.def(t, m)
+	s.def(v, id, t, m)
 	return t
 }
 
 func (s *DomainImpl) Name() string {
-	return s.uri // TODO: hack out second to last url param
+	return s.identity.Name
 }
 
 func (s *DomainImpl) Version() string {
-	return s.uri // TODO: hack out last url param
+	return s.identity.Version.String()
+}
+
+func (s *DomainImpl) Namespace() string {
+	return s.identity.Namespace
+}
+
+func (s *DomainImpl) Identity() cqrs.DomainIdentity {
+	return s.identity
 }
 
 func (s *DomainImpl) SourceUri() string {
@@ -205,10 +456,18 @@ func (s *DomainImpl) Aggregate() cqrs.AggregateState {
 }
 
 func (s *DomainImpl) Message(message_type cqrs.MessageType) cqrs.MessageDefiner {
+	m, err := s.LookupMessage(message_type)
+	if err != nil {
+		panic(err)
+	}
+	return m
+}
+
+func (s *DomainImpl) LookupMessage(message_type cqrs.MessageType) (cqrs.MessageDefiner, error) {
 	if f, ok := s.factory_map[message_type]; ok {
-		return f()
+		return f(), nil
 	}
-	panic(fmt.Sprintf("requested message type [ %X ] from domain [ %s ]", uint32(message_type), s.Uri()))
+	return nil, cqrs.NewHandlerError(cqrs.ErrUnknownMessage, s.uri, message_type, nil)
 }
 
 func (s *DomainImpl) Messages(message_types ...cqrs.MessageType) map[cqrs.MessageType]func() cqrs.MessageDefiner {
@@ -246,7 +505,11 @@ func (s *DomainImpl) Events(message_types ...cqrs.MessageType) map[cqrs.MessageT
 }
 
 func (s *DomainImpl) MessageType(m cqrs.MessageDefiner) cqrs.MessageType {
-	return s.type_map[s.MessageName(m)]
+	t := reflect.TypeOf(m)
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return s.type_map[t]
 }
 
 func (s *DomainImpl) MessageName(m cqrs.MessageDefiner) string {
@@ -258,6 +521,14 @@ func (s *DomainImpl) MessageName(m cqrs.MessageDefiner) string {
 	return n
 }
 
-func (s *DomainImpl) Handler(deps interface{}, command cqrs.Message) cqrs.Message {
+func (s *DomainImpl) Handler(deps interface{}, command cqrs.Message) (cqrs.Message, error) {
 	return s.command_handler(deps, command)
 }
+
+func (s *DomainImpl) HandlerContext(ctx context.Context, deps interface{}, command cqrs.Message) (cqrs.Message, error) {
+	d, ok := deps.(ioc.Dependencies)
+	if !ok {
+		return nil, cqrs.NewHandlerError(cqrs.ErrHandlerFault, s.uri, command.GetMessageType(), ErrInvalidDependencies)
+	}
+	return s.command_handler(ioc.WithContext(ctx, d), command)
+}
@@ -0,0 +1,138 @@
+package domains_test
+
+import (
+	"github.com/xzeus/cqrs"
+	. "github.com/xzeus/cqrs/domains"
+	. "github.com/xzeus/cqrs/testing"
+	"testing"
+)
+
+type conflictAggregate struct {
+	cqrs.AggregateState
+	cqrs.JsonSerialized
+}
+
+type conflictMessage struct {
+	cqrs.JsonSerialized
+	domain cqrs.Domain
+}
+
+func (m *conflictMessage) Domain() cqrs.Domain { return m.domain }
+
+type otherMessage struct {
+	conflictMessage
+}
+
+type malformedMessage struct {
+	conflictMessage
+	Age int `validate:"min=eighteen"`
+}
+
+type definer struct{ domain cqrs.Domain }
+
+func (d *definer) Domain() cqrs.Domain { return d.domain }
+
+func newConflictDomain(uri string) cqrs.Domain {
+	d := &definer{}
+	d.domain = NewDomain(d, uri, &conflictAggregate{})
+	return d.domain
+}
+
+func registrationError(f func()) (err *RegistrationError) {
+	defer func() {
+		if r := recover(); r != nil {
+			err, _ = r.(*RegistrationError)
+		}
+	}()
+	f()
+	return
+}
+
+func Test_Should_reject_duplicate_domain_uri(t *testing.T) {
+	uri := "github.com/xzeus/cqrs/domains/test/duplicate"
+	newConflictDomain(uri)
+	err := registrationError(func() { newConflictDomain(uri) })
+	Assert(t, err != nil, "should have reported the duplicate domain")
+}
+
+func Test_Should_reject_duplicate_message_type(t *testing.T) {
+	d := newConflictDomain("github.com/xzeus/cqrs/domains/test/duplicate_type")
+	d.DefCommand(1, 1, &conflictMessage{domain: d})
+	err := registrationError(func() { d.DefCommand(1, 1, &otherMessage{conflictMessage{domain: d}}) })
+	Assert(t, err != nil, "should have reported the duplicate type id")
+	Equals(t, 1, len(err.Conflicts), "should only report the type id conflict")
+}
+
+func Test_Should_reject_command_redefined_as_event(t *testing.T) {
+	d := newConflictDomain("github.com/xzeus/cqrs/domains/test/mismatch")
+	d.DefCommand(1, 1, &conflictMessage{domain: d})
+	err := registrationError(func() { d.DefEvent(1, 1, &conflictMessage{domain: d}) })
+	Assert(t, err != nil, "should have reported the command/event mismatch")
+}
+
+func Test_Should_reject_message_from_other_domain(t *testing.T) {
+	d := newConflictDomain("github.com/xzeus/cqrs/domains/test/owner")
+	o := newConflictDomain("github.com/xzeus/cqrs/domains/test/other")
+	err := registrationError(func() { d.DefEvent(1, 1, &conflictMessage{domain: o}) })
+	Assert(t, err != nil, "should have reported the foreign message")
+}
+
+func Test_Should_reject_out_of_range_type_id(t *testing.T) {
+	d := newConflictDomain("github.com/xzeus/cqrs/domains/test/range")
+	err := registrationError(func() { d.DefEvent(1, 0x1000001, &conflictMessage{domain: d}) })
+	Assert(t, err != nil, "should have reported the masked type id")
+}
+
+func Test_Should_reject_malformed_validate_tags(t *testing.T) {
+	d := newConflictDomain("github.com/xzeus/cqrs/domains/test/malformed")
+	err := registrationError(func() { d.DefCommand(1, 1, &malformedMessage{conflictMessage{domain: d}, 0}) })
+	Assert(t, err != nil, "should have rejected the tag when defined")
+}
+
+func Test_Should_resolve_message_type_by_go_type(t *testing.T) {
+	d := newConflictDomain("github.com/xzeus/cqrs/domains/test/resolve")
+	c := d.DefCommand(1, 1, &conflictMessage{domain: d})
+	e := d.DefEvent(1, 1, &otherMessage{conflictMessage{domain: d}})
+	Equals(t, c, d.MessageType(&conflictMessage{}), "should resolve the command")
+	Equals(t, e, d.MessageType(&otherMessage{}), "should resolve the event")
+}
+
+func Test_Should_define_versions_side_by_side(t *testing.T) {
+	v1 := newConflictDomain("github.com/xzeus/cqrs/domains/test/versioned/v1")
+	v2 := newConflictDomain("github.com/xzeus/cqrs/domains/test/versioned/v2_1")
+	Equals(t, "versioned", v1.Name(), "should parse the name")
+	Equals(t, "v2.1.0", v2.Version(), "should parse the version")
+	NotEquals(t, v1.Id(), v2.Id(), "versions should have distinct ids")
+	latest, found := Meta().Latest("github.com/xzeus/cqrs/domains/test/versioned")
+	Assert(t, found, "should find the domain by key")
+	Equals(t, v2.Uri(), latest.Uri, "should pick the highest version")
+	err := registrationError(func() { newConflictDomain("github.com/xzeus/cqrs/domains/test/versioned/v2.1.0") })
+	Assert(t, err != nil, "should have reported the duplicate version")
+}
+
+func Test_Should_report_commands_without_handler(t *testing.T) {
+	d := newConflictDomain("github.com/xzeus/cqrs/domains/test/unhandled").(*DomainImpl)
+	handled := d.DefCommand(1, 1, &conflictMessage{domain: d}, func(cqrs.CommandHandlerDef) cqrs.CommandHandlerFunc { return nil })
+	unhandled := d.DefCommand(1, 2, &otherMessage{conflictMessage{domain: d}})
+	Equals(t, []cqrs.MessageType{unhandled}, d.Unhandled(), "should only report [ %X ] and not [ %X ]", uint32(unhandled), uint32(handled))
+	conflict := "[ " + d.Uri() + " ] command [ otherMessage ] has no handler"
+	err, ok := Meta().Validate().(*RegistrationError)
+	Assert(t, ok, "should fail validation")
+	Assert(t, contains(err.Conflicts, conflict), "should report %s in %v", conflict, err.Conflicts)
+	other := newConflictDomain("github.com/xzeus/cqrs/domains/test/handled")
+	Ok(t, Meta().Validate(other))
+	d.DefCommandHandler(func(cqrs.CommandHandlerDef) cqrs.CommandHandlerFunc { return nil })
+	Equals(t, 0, len(d.Unhandled()), "should fall back to the domain handler")
+	if err, ok := Meta().Validate().(*RegistrationError); ok {
+		Assert(t, !contains(err.Conflicts, conflict), "should no longer report %s", conflict)
+	}
+}
+
+func contains(l []string, v string) bool {
+	for _, s := range l {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
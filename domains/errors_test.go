@@ -0,0 +1,127 @@
+package domains_test
+
+import (
+	"context"
+	"errors"
+	"github.com/xzeus/cqrs"
+	. "github.com/xzeus/cqrs/domains"
+	"github.com/xzeus/cqrs/exception"
+	"github.com/xzeus/cqrs/ioc"
+	. "github.com/xzeus/cqrs/testing"
+	"github.com/xzeus/cqrs/testing/testdeps"
+	"testing"
+)
+
+type faultyAggregate struct{}
+
+func (a *faultyAggregate) Init() cqrs.AggregateState  { return &faultyAggregate{} }
+func (a *faultyAggregate) Handle(cqrs.MessageDefiner) {}
+
+type faultyDefiner struct{}
+
+func (faultyDefiner) Domain() cqrs.Domain { return faultyDomain }
+
+type Explode struct {
+	cqrs.JsonSerialized
+	faultyDefiner
+}
+
+type Skip struct {
+	cqrs.JsonSerialized
+	faultyDefiner
+}
+
+type Skipped struct {
+	cqrs.JsonSerialized
+	faultyDefiner
+}
+
+type Claim struct {
+	cqrs.JsonSerialized
+	faultyDefiner
+}
+
+type Claimed struct {
+	cqrs.JsonSerialized
+	cqrs.Keyed
+	faultyDefiner
+}
+
+var (
+	faultyDomain = NewDomain(faultyDefiner{}, "github.com/xzeus/cqrs/domains/test/faulty/v1", &faultyAggregate{})
+
+	C_Explode = faultyDomain.DefCommand(1, 1, &Explode{}, func(cqrs.CommandHandlerDef) cqrs.CommandHandlerFunc {
+		return func(cqrs.AggregateHeader, cqrs.AggregateState, cqrs.Message, cqrs.MessageDefiner) {
+			panic("boom")
+		}
+	})
+
+	C_Skip = faultyDomain.DefCommand(1, 2, &Skip{}, func(h cqrs.CommandHandlerDef) cqrs.CommandHandlerFunc {
+		return func(header cqrs.AggregateHeader, _ cqrs.AggregateState, _ cqrs.Message, _ cqrs.MessageDefiner) {
+			h.Publish(&Skipped{}, cqrs.WithOptions(cqrs.NewMessageOptions(header.GetId(), header.GetVersion()+2, 0)))
+		}
+	})
+
+	C_Claim = faultyDomain.DefCommand(1, 3, &Claim{}, func(h cqrs.CommandHandlerDef) cqrs.CommandHandlerFunc {
+		return func(cqrs.AggregateHeader, cqrs.AggregateState, cqrs.Message, cqrs.MessageDefiner) {
+			h.Publish(&Claimed{Keyed: cqrs.Keyed{AggregateKey: []byte("claimed")}})
+		}
+	})
+
+	E_Skipped = faultyDomain.DefEvent(1, 1, &Skipped{})
+	E_Claimed = faultyDomain.DefEvent(1, 2, &Claimed{})
+)
+
+func Test_Should_return_unknown_message_for_unregistered_type(t *testing.T) {
+	command := cqrs.NewMessage(1, 0, 0, cqrs.NoOrigin, &Skip{}).Reference()
+	command.MessageType = cqrs.MakeVersionedCommandType(1, 99)
+	result, err := faultyDomain.Handler(testdeps.NewDependencies(), command)
+	Assert(t, errors.Is(err, cqrs.ErrUnknownMessage), "should identify the unknown type [ %s ]", err)
+	Equals(t, nil, result, "should not have appended anything")
+}
+
+func Test_Should_return_handler_fault_when_handler_panics(t *testing.T) {
+	command := cqrs.NewMessage(2, 0, 0, cqrs.NoOrigin, &Explode{})
+	result, err := faultyDomain.Handler(testdeps.NewDependencies(), command)
+	Assert(t, errors.Is(err, cqrs.ErrHandlerFault), "should report the fault [ %s ]", err)
+	Equals(t, exception.E_Internal, result.GetMessageType(), "should still append the error event")
+	Equals(t, exception.Domain.Id(), result.GetDomainId(), "should append to the exception domain")
+}
+
+func Test_Should_return_conflict_for_stale_version(t *testing.T) {
+	command := cqrs.NewMessage(3, 0, 0, cqrs.NoOrigin, &Skip{})
+	result, err := faultyDomain.Handler(testdeps.NewDependencies(), command)
+	Assert(t, errors.Is(err, cqrs.ErrConflict), "should report the conflict [ %s ]", err)
+	Equals(t, exception.E_Conflict, result.GetMessageType(), "should append a conflict event")
+	herr := &cqrs.HandlerError{}
+	Assert(t, errors.As(err, &herr), "should be a handler error")
+	Equals(t, C_Skip, herr.MessageType, "should identify the command")
+}
+
+// keyFailingStore fails every keyed append as if the key were taken
+type keyFailingStore struct {
+	*testdeps.Mock_EventStore
+}
+
+func (s keyFailingStore) AppendKeyedEvent([]byte, []cqrs.AggregateHeader, cqrs.MessageDefiner) (cqrs.Message, error) {
+	return nil, ioc.ErrAggregateKeyCollision
+}
+
+func (s keyFailingStore) WithContext(context.Context) ioc.EventStoreReaderWriter { return s }
+
+type keyFailingDeps struct {
+	*testdeps.Dependencies
+}
+
+func (d keyFailingDeps) EventStore() ioc.EventStoreReaderWriter {
+	return keyFailingStore{d.Mock_EventStore}
+}
+
+func Test_Should_append_fault_by_id_when_keyed_append_fails(t *testing.T) {
+	command := cqrs.NewMessage(4, 0, 0, cqrs.NoOrigin, &Claim{})
+	result, err := faultyDomain.Handler(keyFailingDeps{testdeps.NewDependencies()}, command)
+	Assert(t, errors.Is(err, cqrs.ErrConflict), "should report the conflict [ %s ]", err)
+	Assert(t, result != nil, "should have appended the fault")
+	Equals(t, exception.E_Conflict, result.GetMessageType(), "should append a conflict event")
+	Equals(t, exception.Domain.Id(), result.GetDomainId(), "should append to the exception domain")
+}
@@ -1,6 +1,8 @@
 package domains
 
 import (
+	"context"
+	"fmt"
 	"github.com/xzeus/cqrs"
 	"github.com/xzeus/cqrs/ioc"
 )
@@ -10,54 +12,122 @@ type eventHandlerDef struct {
 	domain        cqrs.Domain
 	event         cqrs.Message
 	event_payload cqrs.MessageDefiner
+	span          ioc.Span
+	err           error // First failure publishing a command
 }
 
 var default_command_options = cqrs.NewMessageOptions(0, 1, int64(0))
 
-func NewEventHandler(deps ioc.Dependencies, domain cqrs.Domain, event cqrs.Message) cqrs.EventHandlerDef {
-	event_domain := Meta().Domains[event.GetDomainId()].Domain
+func NewEventHandler(deps ioc.Dependencies, domain cqrs.Domain, event cqrs.Message) (cqrs.EventHandlerDef, error) {
+	m, found := Meta().Domains[event.GetDomainId()]
+	if !found {
+		return nil, cqrs.NewHandlerError(cqrs.ErrUnknownMessage, domain.Uri(), event.GetMessageType(), fmt.Errorf("domain [ %X ] not registered", uint32(event.GetDomainId())))
+	}
+	event_domain := m.Domain
+	event_payload, err := event_domain.LookupMessage(event.GetMessageType())
+	if err != nil {
+		return nil, err
+	}
+	if err := cqrs.Extract(event_payload, event); err != nil {
+		return nil, cqrs.NewHandlerError(cqrs.ErrDecodeFailure, event_domain.Uri(), event.GetMessageType(), err)
+	}
 	handler := &eventHandlerDef{
 		deps:          deps,
 		domain:        domain,
 		event:         event,
-		event_payload: event_domain.Message(event.GetMessageType()),
+		event_payload: event_payload,
 	}
-	if err := cqrs.Extract(handler.event_payload, event); err != nil {
-		panic("Shouldn't ever receive an event that can't be unserialized")
-	}
-	return handler
+	parent, _ := ioc.ParseTraceParent(event.GetTraceParent())
+	handler.span = deps.Tracer().Start("event "+domain.Name()+"/"+event_domain.MessageName(handler.event_payload), parent)
+	handler.span.SetAttributes(ioc.Fields{
+		ioc.FieldServiceKey:  domain.Uri(),
+		ioc.FieldDomain:      event_domain.Uri(),
+		ioc.FieldAggregateId: fmt.Sprintf("%X", uint64(event.GetId())),
+		ioc.FieldVersion:     event.GetVersion(),
+	})
+	return handler, nil
+}
+
+func (h *eventHandlerDef) Context() context.Context {
+	return ioc.Context(h.deps)
 }
 
 func (h *eventHandlerDef) Deps() interface{} {
 	return h.deps
 }
 
-func (h *eventHandlerDef) Exec(handler cqrs.EventHandlerFunc) {
+// Exec returns the first error from a command the handler published, or a
+// handler fault if it panicked
+func (h *eventHandlerDef) Exec(handler cqrs.EventHandlerFunc) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = cqrs.NewHandlerError(cqrs.ErrHandlerFault, h.domain.Uri(), h.event.GetMessageType(), fmt.Errorf("%v", r))
+		}
+		h.span.SetError(err)
+		h.span.End()
+	}()
+	if err := h.Context().Err(); err != nil { // Caller has gone away
+		h.deps.Logger().With(ioc.Fields{ioc.FieldError: err}).Warnf("event handler skipped [ %s ]", h.domain.Uri())
+		return cqrs.NewHandlerError(cqrs.ErrCancelled, h.domain.Uri(), h.event.GetMessageType(), err)
+	}
 	handler(h.event, h.event_payload)
+	return h.err
 }
 
-func (h *eventHandlerDef) Publish(command_payload cqrs.MessageDefiner, options ...func(*cqrs.MessageOptionsDef)) cqrs.Message {
+func (h *eventHandlerDef) Publish(command_payload cqrs.MessageDefiner, options ...func(*cqrs.MessageOptionsDef)) (cqrs.Message, error) {
 	var domain = command_payload.Domain()
 	var command_options = cqrs.NewMessageOptions(0, 0, int64(0))
 	for _, modifier := range options {
 		modifier(command_options)
 	}
-	l := len(h.event.GetOrigin()) + 1
-	o := make([]cqrs.AggregateHeader, l, l)
-	o[0] = h.event.Body()
-	for i, d := range h.event.GetOrigin() {
-		o[i+1] = d
-	}
 	command := cqrs.NewMessage(
 		command_options.Id(),
 		command_options.Version(),
 		command_options.Timestamp(),
-		o,
+		h.origin(),
 		command_payload)
-	return domain.Handler(h.deps, command)
+	command = cqrs.WithTraceParent(command, h.span.Context().TraceParent())
+	result, err := domain.Handler(h.deps, command)
+	if err != nil && h.err == nil {
+		h.err = err
+	}
+	return result, err
+}
+
+// Error appends an error event caused by the handled event, there's no
+// command to reject so it's recorded directly
+func (h *eventHandlerDef) Error(message string, args ...interface{}) (cqrs.Message, error) {
+	if err := h.Context().Err(); err != nil { // Caller has gone away so nothing is recorded
+		err = cqrs.NewHandlerError(cqrs.ErrCancelled, h.domain.Uri(), h.event.GetMessageType(), err)
+		if h.err == nil {
+			h.err = err
+		}
+		return nil, err
+	}
+	payload, options := h.deps.Exception().Error(message, args...)
+	result, err := h.deps.EventStore().AppendEvent(options.Id(), options.Version(), h.origin(), payload)
+	if err != nil {
+		kind := cqrs.ErrStoreUnavailable
+		if h.Context().Err() != nil {
+			kind = cqrs.ErrCancelled
+		}
+		err = cqrs.NewHandlerError(kind, h.domain.Uri(), h.event.GetMessageType(), err)
+		if h.err == nil {
+			h.err = err
+		}
+		return nil, err
+	}
+	h.deps.Publisher().Publish(cqrs.WithTraceParent(result, h.span.Context().TraceParent()))
+	return result, nil
 }
 
-func (h *eventHandlerDef) Error(message string, args ...interface{}) cqrs.Message {
-	command, options := h.deps.Exception().Error(message, args...)
-	return h.Publish(command, cqrs.WithOptions(options))
+// origin is the handled event followed by it's own origin
+func (h *eventHandlerDef) origin() []cqrs.AggregateHeader {
+	l := len(h.event.GetOrigin()) + 1
+	o := make([]cqrs.AggregateHeader, l, l)
+	o[0] = h.event.Body()
+	for i, d := range h.event.GetOrigin() {
+		o[i+1] = d
+	}
+	return o
 }
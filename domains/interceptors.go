@@ -0,0 +1,28 @@
+package domains
+
+import (
+	"github.com/xzeus/cqrs"
+	"github.com/xzeus/cqrs/ioc"
+)
+
+// Validation rejects commands whose payload fails the rules declared in it's
+// validate tags before the aggregate is hydrated, it is defined globally
+// by default
+func Validation(h cqrs.CommandHandlerDef) cqrs.CommandHooks {
+	return cqrs.CommandHooks{
+		BeforeHydrate: func() {
+			if h.EventPayload() != nil {
+				return
+			}
+			if errs := cqrs.Validate(h.CommandPayload()); len(errs) > 0 {
+				deps := h.Deps().(ioc.Dependencies)
+				payload, options := deps.Exception().Invalid(h.Command(), errs)
+				if c, ok := h.(*commandHandlerDef); ok {
+					c.publishWith(payload, options)
+					return
+				}
+				h.Publish(payload, cqrs.WithOptions(options))
+			}
+		},
+	}
+}
@@ -0,0 +1,96 @@
+package domains_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/xzeus/cqrs"
+	"github.com/xzeus/cqrs/domains"
+	"github.com/xzeus/cqrs/exception"
+	. "github.com/xzeus/cqrs/testing"
+	"github.com/xzeus/cqrs/testing/testdeps"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func Test_Should_run_interceptor_stages_in_order(t *testing.T) {
+	stages := make([]string, 0)
+	counterDomain.DefInterceptor(func(h cqrs.CommandHandlerDef) cqrs.CommandHooks {
+		if h.Command().GetId() != 1 { // Stays defined for the other tests' commands
+			return cqrs.CommandHooks{}
+		}
+		return cqrs.CommandHooks{
+			BeforeHydrate: func() { stages = append(stages, "hydrate") },
+			AfterHandler:  func() { stages = append(stages, "handler") },
+			AfterAppend: func(result cqrs.Message) {
+				stages = append(stages, "append")
+				Equals(t, int32(1), result.GetVersion(), "should see the appended event")
+			},
+		}
+	})
+	deps := testdeps.NewDependencies()
+	result, err := counterDomain.Handler(deps, cqrs.NewMessage(1, 0, 0, cqrs.NoOrigin, &Increment{By: 2}))
+	Ok(t, err)
+	Equals(t, E_Incremented, result.GetMessageType(), "should have appended the event")
+	Equals(t, []string{"hydrate", "handler", "append"}, stages, "should run each stage once")
+	Equals(t, 1, len(deps.Mock_Publisher.Published), "should have published the result")
+	buffer := new(bytes.Buffer)
+	deps.Mock_Metrics.WritePrometheus(buffer)
+	Assert(t, strings.Contains(buffer.String(), `cqrs_commands_total{command="Increment",domain="counter@v1.0.0",result="accepted"} 1`), "should count the command [ %s ]", buffer)
+}
+
+func Test_Should_reject_invalid_command_before_handler(t *testing.T) {
+	deps := testdeps.NewDependencies()
+	result, err := counterDomain.Handler(deps, cqrs.NewMessage(2, 0, 0, cqrs.NoOrigin, &Increment{By: 0}))
+	Ok(t, err)
+	e, ok := exception.AsValidationFailed(result)
+	Assert(t, ok, "should have published a validation failure")
+	Equals(t, "by", e.Fields[0].Field, "should identify the field")
+	Equals(t, C_Increment, e.Command, "should identify the command")
+}
+
+func Test_Should_reject_invalid_commands_concurrently(t *testing.T) {
+	var wg sync.WaitGroup
+	for i := int64(0); i < 4; i++ {
+		wg.Add(1)
+		go func(id int64) {
+			defer wg.Done()
+			result, err := counterDomain.Handler(testdeps.NewDependencies(), cqrs.NewMessage(0x31+id, 0, 0, cqrs.NoOrigin, &Increment{By: 0}))
+			Ok(t, err)
+			_, ok := exception.AsValidationFailed(result)
+			Assert(t, ok, "should have published a validation failure")
+		}(i)
+	}
+	wg.Wait()
+}
+
+func Test_Should_trace_command_within_callers_trace(t *testing.T) {
+	deps := testdeps.NewDependencies()
+	parent := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	command := cqrs.WithTraceParent(cqrs.NewMessage(3, 0, 0, cqrs.NoOrigin, &Increment{By: 1}), parent)
+	counterDomain.Handler(deps, command)
+	spans := deps.Mock_Spans.Spans
+	Assert(t, len(spans) >= 3, "should have spanned the load, append and command [ %d ]", len(spans))
+	for _, span := range spans {
+		Equals(t, "4bf92f3577b34da6a3ce929d0e0e4736", span.TraceId, "should continue the trace [ %s ]", span.Name)
+	}
+	Equals(t, "command counter/Increment", spans[len(spans)-1].Name, "should end the command span last")
+	published := deps.Mock_Publisher.Published[0]
+	Assert(t, strings.HasPrefix(published.GetTraceParent(), "00-4bf92f"), "should propagate the trace to event handlers")
+}
+
+func Test_Should_reject_command_with_cancelled_context(t *testing.T) {
+	deps := testdeps.NewDependencies()
+	command := cqrs.NewMessage(4, 0, 0, cqrs.NoOrigin, &Increment{By: 1})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	result, err := counterDomain.HandlerContext(ctx, deps, command)
+	Assert(t, errors.Is(err, cqrs.ErrCancelled), "should report the command as cancelled [ %s ]", err)
+	Assert(t, !errors.Is(err, cqrs.ErrStoreUnavailable), "should not blame the store [ %s ]", err)
+	Assert(t, errors.Is(err, context.Canceled), "should wrap the context's error [ %s ]", err)
+	Assert(t, result == nil, "should not have recorded an event")
+	Equals(t, 0, len(deps.Mock_Publisher.Published), "should not publish anything")
+	_, err = counterDomain.Handler(struct{}{}, command)
+	Assert(t, errors.Is(err, domains.ErrInvalidDependencies), "should reject dependencies of the wrong type [ %s ]", err)
+}
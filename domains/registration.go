@@ -0,0 +1,31 @@
+package domains
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// RegistrationError describes every conflict found while defining a domain or
+// one of its messages.  Domains are defined during package init so the error
+// is raised as a panic rather than letting a later definition silently replace
+// an earlier one.
+type RegistrationError struct {
+	Uri       string
+	Conflicts []string
+}
+
+func NewRegistrationError(uri string, message string, args ...interface{}) *RegistrationError {
+	return &RegistrationError{
+		Uri:       uri,
+		Conflicts: []string{fmt.Sprintf(message, args...)},
+	}
+}
+
+func (e *RegistrationError) Error() string {
+	return fmt.Sprintf("domain [ %s ] registration conflict(s):\n\t%s", e.Uri, strings.Join(e.Conflicts, "\n\t"))
+}
+
+func typeName(t reflect.Type) string {
+	return t.PkgPath() + "." + t.Name()
+}
@@ -0,0 +1,102 @@
+package domains
+
+import (
+	"github.com/xzeus/cqrs"
+)
+
+// TypedDomain layers compile time checked command handlers and event
+// appliers over a DomainImpl whose aggregate state is the concrete type S.
+// The state doesn't need to implement cqrs.AggregateState, events are
+// applied to it through the appliers registered with DefTypedEvent.
+type TypedDomain[S any] struct {
+	*DomainImpl
+	init     func() *S
+	appliers map[cqrs.MessageType]func(*S, cqrs.MessageDefiner)
+}
+
+// TypedCommand is passed to typed command handlers and carries the hydrated
+// state and decoded payload alongside the usual handler actions
+type TypedCommand[S any, C any] struct {
+	cqrs.CommandHandlerDef
+	State   *S
+	Payload *C
+}
+
+type typedState[S any] struct {
+	domain *TypedDomain[S]
+	state  *S
+}
+
+func (a *typedState[S]) Init() cqrs.AggregateState {
+	return &typedState[S]{
+		domain: a.domain,
+		state:  a.domain.init(),
+	}
+}
+
+func (a *typedState[S]) Handle(m cqrs.MessageDefiner) {
+	if apply, found := a.domain.appliers[a.domain.MessageType(m)]; found {
+		apply(a.state, m)
+	} // Events without an applier don't change the state
+}
+
+// NewTypedDomain defines a domain at uri whose aggregate state is created by
+// init, or by new(S) when init is nil
+func NewTypedDomain[S any](domain cqrs.DomainDefiner, uri string, init func() *S) *TypedDomain[S] {
+	if init == nil {
+		init = func() *S { return new(S) }
+	}
+	d := &TypedDomain[S]{
+		init:     init,
+		appliers: make(map[cqrs.MessageType]func(*S, cqrs.MessageDefiner)),
+	}
+	d.DomainImpl = newDomainImpl(domain, uri, func() cqrs.AggregateState {
+		return (&typedState[S]{domain: d}).Init()
+	})
+	return d
+}
+
+// State unwraps the concrete state from an aggregate created by this domain
+func (d *TypedDomain[S]) State(a cqrs.AggregateState) *S {
+	if t, ok := a.(*typedState[S]); ok {
+		return t.state
+	}
+	return nil
+}
+
+// DefTypedCommand defines the command C and binds its handler, the payload
+// handed to the handler is already decoded as *C
+func DefTypedCommand[S any, C any, PC interface {
+	*C
+	cqrs.MessageDefiner
+}](d *TypedDomain[S], v uint8, id uint32, handler func(*TypedCommand[S, C])) cqrs.MessageType {
+	t := cqrs.MakeVersionedCommandType(v, id)
+	d.defFactory(v, id, t, PC(new(C)), func() cqrs.MessageDefiner { return PC(new(C)) })
+	d.defCommandHandler(t, func(h cqrs.CommandHandlerDef) cqrs.CommandHandlerFunc {
+		return func(header cqrs.AggregateHeader, state cqrs.AggregateState, command cqrs.Message, payload cqrs.MessageDefiner) {
+			handler(&TypedCommand[S, C]{
+				CommandHandlerDef: h,
+				State:             d.State(state),
+				Payload:           (*C)(payload.(PC)),
+			})
+		}
+	})
+	return t
+}
+
+// DefTypedEvent defines the event E and the applier used to fold it into
+// the aggregate state during hydration, apply may be nil for events that
+// don't change the state
+func DefTypedEvent[S any, E any, PE interface {
+	*E
+	cqrs.MessageDefiner
+}](d *TypedDomain[S], v uint8, id uint32, apply func(*S, *E)) cqrs.MessageType {
+	t := cqrs.MakeVersionedEventType(v, id)
+	d.defFactory(v, id, t, PE(new(E)), func() cqrs.MessageDefiner { return PE(new(E)) })
+	if apply != nil {
+		d.appliers[t] = func(s *S, m cqrs.MessageDefiner) {
+			apply(s, (*E)(m.(PE)))
+		}
+	}
+	return t
+}
@@ -0,0 +1,102 @@
+package domains_test
+
+import (
+	"github.com/xzeus/cqrs"
+	. "github.com/xzeus/cqrs/domains"
+	. "github.com/xzeus/cqrs/testing"
+	"github.com/xzeus/cqrs/testing/testdeps"
+	"testing"
+)
+
+type counter struct {
+	Count int
+}
+
+type counterDefiner struct{}
+
+func (counterDefiner) Domain() cqrs.Domain { return counterDomain }
+
+type Increment struct {
+	cqrs.JsonSerialized
+	counterDefiner
+	By int `json:"by" validate:"min=1"`
+}
+
+type Incremented struct {
+	cqrs.JsonSerialized
+	counterDefiner
+	By int `json:"by"`
+}
+
+var (
+	counterDomain = NewTypedDomain[counter](counterDefiner{}, "github.com/xzeus/cqrs/domains/test/counter/v1", nil)
+
+	C_Increment = DefTypedCommand(counterDomain, 1, 1, func(c *TypedCommand[counter, Increment]) {
+		c.Publish(&Incremented{By: c.Payload.By})
+	})
+
+	E_Incremented = DefTypedEvent(counterDomain, 1, 1, func(s *counter, e *Incremented) {
+		s.Count += e.By
+	})
+)
+
+func Test_Should_apply_typed_events_to_state(t *testing.T) {
+	a := counterDomain.Aggregate()
+	a.Handle(&Incremented{By: 2})
+	a.Handle(&Incremented{By: 3})
+	Equals(t, 5, counterDomain.State(a).Count, "should have applied both events")
+}
+
+func Test_Should_resolve_typed_message_types(t *testing.T) {
+	Equals(t, C_Increment, counterDomain.MessageType(&Increment{}), "should resolve the command")
+	Equals(t, E_Incremented, counterDomain.MessageType(&Incremented{}), "should resolve the event")
+	_, ok := counterDomain.Message(E_Incremented).(*Incremented)
+	Assert(t, ok, "should create typed event payloads")
+}
+
+type tally struct {
+	Total int
+}
+
+type tallyDefiner struct{}
+
+func (tallyDefiner) Domain() cqrs.Domain { return tallyDomain }
+
+type Add struct {
+	cqrs.JsonSerialized
+	tallyDefiner
+	By int `json:"by"`
+}
+
+type Added struct {
+	cqrs.JsonSerialized
+	tallyDefiner
+	By    int `json:"by"`
+	Total int `json:"total"`
+}
+
+var (
+	tallyDomain = NewTypedDomain[tally](tallyDefiner{}, "github.com/xzeus/cqrs/domains/test/tally/v1", nil)
+
+	C_Add = DefTypedCommand(tallyDomain, 1, 1, func(c *TypedCommand[tally, Add]) {
+		c.Publish(&Added{By: c.Payload.By, Total: c.State.Total + c.Payload.By})
+	})
+
+	E_Added = DefTypedEvent(tallyDomain, 1, 1, func(s *tally, e *Added) {
+		s.Total = e.Total
+	})
+)
+
+func Test_Should_handle_typed_command_against_hydrated_state(t *testing.T) {
+	deps := testdeps.NewDependencies()
+	_, err := tallyDomain.Handler(deps, cqrs.NewMessage(0x28, 0, 0, cqrs.NoOrigin, &Add{By: 2}))
+	Ok(t, err)
+	result, err := tallyDomain.Handler(deps, cqrs.NewMessage(0x28, 0, 0, cqrs.NoOrigin, &Add{By: 3}))
+	Ok(t, err)
+	Equals(t, E_Added, result.GetMessageType(), "should publish the typed event")
+	Equals(t, int32(2), result.GetVersion(), "should append after the first event")
+	added := &Added{}
+	Ok(t, cqrs.Extract(added, result))
+	Equals(t, 3, added.By, "should decode the typed payload")
+	Equals(t, 5, added.Total, "should apply the first event before handling")
+}
@@ -0,0 +1,66 @@
+package cqrs
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Kinds of failure returned by Domain.Handler and event handlers, a
+// HandlerError matches it's kind with errors.Is
+var (
+	// ErrUnknownMessage is used when a message type isn't registered with
+	// the domain it was sent to
+	ErrUnknownMessage = errors.New("unknown message type")
+
+	// ErrDecodeFailure is used when a message's data can't be extracted
+	// into it's payload
+	ErrDecodeFailure = errors.New("unable to decode message")
+
+	// ErrStoreUnavailable is used when the event store couldn't load or
+	// append events
+	ErrStoreUnavailable = errors.New("store unavailable")
+
+	// ErrCancelled is used when the caller's context is done before the
+	// message was handled, nothing is recorded for it
+	ErrCancelled = errors.New("cancelled")
+
+	// ErrConflict is used when the event store rejected an append because
+	// the aggregate version or id was already taken
+	ErrConflict = errors.New("aggregate version conflict")
+
+	// ErrHandlerFault is used when a handler panics or isn't defined
+	ErrHandlerFault = errors.New("handler fault")
+)
+
+// HandlerError describes why a message couldn't be handled, any error event
+// which was still appended is returned alongside it
+type HandlerError struct {
+	Kind        error // One of the Err* kinds
+	Domain      string
+	MessageType MessageType
+	Err         error // Underlying cause, may be nil
+}
+
+func NewHandlerError(kind error, domain string, message_type MessageType, err error) *HandlerError {
+	return &HandlerError{
+		Kind:        kind,
+		Domain:      domain,
+		MessageType: message_type,
+		Err:         err,
+	}
+}
+
+func (e *HandlerError) Error() string {
+	if e.Err == nil {
+		return fmt.Sprintf("%s [ %s - %X ]", e.Kind, e.Domain, uint32(e.MessageType))
+	}
+	return fmt.Sprintf("%s [ %s - %X ]: %s", e.Kind, e.Domain, uint32(e.MessageType), e.Err)
+}
+
+// Unwrap exposes both the kind and the cause to errors.Is and errors.As
+func (e *HandlerError) Unwrap() []error {
+	if e.Err == nil {
+		return []error{e.Kind}
+	}
+	return []error{e.Kind, e.Err}
+}
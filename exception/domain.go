@@ -0,0 +1,36 @@
+package exception
+
+import (
+	"github.com/xzeus/cqrs"
+	"github.com/xzeus/cqrs/domains"
+)
+
+const (
+	Uri = "github.com/xzeus/cqrs/exception/v1"
+)
+
+type __ struct{}
+
+func (_ __) Domain() cqrs.Domain { return Domain }
+
+var (
+	Domain = domains.NewDomain(&__{}, Uri, &ExceptionAggregate{})
+
+	E_ValidationFailed = Domain.DefEvent(1, 1, &ValidationFailed{})
+	E_Rejected         = Domain.DefEvent(1, 2, &Rejected{})
+	E_Conflict         = Domain.DefEvent(1, 3, &Conflict{})
+	E_Internal         = Domain.DefEvent(1, 4, &Internal{})
+)
+
+// ExceptionAggregate carries no state, each exception event stands alone
+type ExceptionAggregate struct {
+	cqrs.AggregateState
+	cqrs.JsonSerialized
+	__
+}
+
+func (a *ExceptionAggregate) Init() cqrs.AggregateState {
+	return &ExceptionAggregate{}
+}
+
+func (a *ExceptionAggregate) Handle(cqrs.MessageDefiner) {}
@@ -0,0 +1,105 @@
+package exception
+
+import (
+	"github.com/xzeus/cqrs"
+	"github.com/xzeus/cqrs/ioc"
+)
+
+// Details are carried by every exception event
+type Details struct {
+	Code      int                      `json:"code"`
+	Message   string                   `json:"message"`
+	Command   cqrs.MessageType         `json:"command"`
+	Aggregate cqrs.AggregateHeaderData `json:"aggregate"`
+}
+
+func (d Details) GetDetails() Details {
+	return d
+}
+
+// NewDetails describes a failure of the provided command, which may be nil
+// when the failure isn't tied to a command
+func NewDetails(command cqrs.Message, code int, message string) Details {
+	d := Details{
+		Code:    code,
+		Message: message,
+	}
+	if command != nil {
+		d.Command = command.GetMessageType()
+		d.Aggregate = command.Body()
+	}
+	return d
+}
+
+// Event is implemented by every exception event
+type Event interface {
+	cqrs.MessageDefiner
+	GetDetails() Details
+}
+
+// ValidationFailed is published in place of a command's result when the
+// command payload fails the rules declared in it's validate tags
+type ValidationFailed struct {
+	cqrs.JsonSerialized
+	__
+	Details
+	Fields cqrs.ValidationErrors `json:"fields"`
+}
+
+// Rejected is published when a handler refuses a command, the code tells
+// apart invalid, forbidden and missing aggregates
+type Rejected struct {
+	cqrs.JsonSerialized
+	__
+	Details
+}
+
+// Conflict is published when the aggregate's version was taken by another
+// command before the result could be appended
+type Conflict struct {
+	cqrs.JsonSerialized
+	__
+	Details
+}
+
+// Internal is published when a command couldn't be handled because of a
+// store or handler fault
+type Internal struct {
+	cqrs.JsonSerialized
+	__
+	Details
+}
+
+// NewValidationFailed describes the failed rules for the provided command
+func NewValidationFailed(command cqrs.Message, errs cqrs.ValidationErrors) *ValidationFailed {
+	return &ValidationFailed{
+		Details: NewDetails(command, ioc.CodeInvalid, errs.Error()),
+		Fields:  errs,
+	}
+}
+
+// AsValidationFailed extracts the payload if the message is a
+// ValidationFailed event
+func AsValidationFailed(message cqrs.Message) (*ValidationFailed, bool) {
+	if e, ok := AsEvent(message); ok {
+		v, ok := e.(*ValidationFailed)
+		return v, ok
+	}
+	return nil, false
+}
+
+// AsEvent extracts the payload if the message is any exception event
+func AsEvent(message cqrs.Message) (Event, bool) {
+	if message == nil || message.GetDomainId() != Domain.Id() {
+		return nil, false
+	}
+	payload, err := Domain.LookupMessage(message.GetMessageType())
+	if err != nil {
+		return nil, false
+	}
+	if err := cqrs.Extract(payload, message); err != nil {
+		return nil, false
+	}
+	e, ok := payload.(Event)
+	return e, ok
+}
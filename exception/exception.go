@@ -0,0 +1,61 @@
+package exception
+
+import (
+	"errors"
+	"fmt"
+	"github.com/xzeus/cqrs"
+	"github.com/xzeus/cqrs/ioc"
+)
+
+// InternalMessage replaces the cause of a fault in the Internal event
+const InternalMessage = "internal error"
+
+type exceptions struct {
+	deps ioc.Dependencies
+}
+
+// NewException is the default ioc.Exception, each error event is the first
+// and only event of a new aggregate in the exception domain whose id is
+// drawn from the deps' Crypto
+func NewException(deps ioc.Dependencies) ioc.Exception {
+	return &exceptions{deps: deps}
+}
+
+func (e *exceptions) options() *cqrs.MessageOptionsDef {
+	return cqrs.NewMessageOptions(e.deps.Crypto().RandInt64(), 1, 0)
+}
+
+func (e *exceptions) Error(message string, args ...interface{}) (cqrs.MessageDefiner, *cqrs.MessageOptionsDef) {
+	return &Rejected{Details: NewDetails(nil, ioc.CodeInvalid, fmt.Sprintf(message, args...))}, e.options()
+}
+
+func (e *exceptions) Panic(message string, args ...interface{}) (cqrs.MessageDefiner, *cqrs.MessageOptionsDef) {
+	return &Internal{Details: NewDetails(nil, ioc.CodeInternal, fmt.Sprintf(message, args...))}, e.options()
+}
+
+func (e *exceptions) Invalid(command cqrs.Message, errs cqrs.ValidationErrors) (cqrs.MessageDefiner, *cqrs.MessageOptionsDef) {
+	return NewValidationFailed(command, errs), e.options()
+}
+
+func (e *exceptions) Reject(command cqrs.Message, code int, message string, args ...interface{}) (cqrs.MessageDefiner, *cqrs.MessageOptionsDef) {
+	return &Rejected{Details: NewDetails(command, code, fmt.Sprintf(message, args...))}, e.options()
+}
+
+func (e *exceptions) Fault(command cqrs.Message, err error) (cqrs.MessageDefiner, *cqrs.MessageOptionsDef) {
+	switch {
+	case errors.Is(err, cqrs.ErrConflict):
+		return &Conflict{Details: NewDetails(command, ioc.CodeConflict, err.Error())}, e.options()
+	case errors.Is(err, cqrs.ErrUnknownMessage), errors.Is(err, cqrs.ErrDecodeFailure):
+		return &Rejected{Details: NewDetails(command, ioc.CodeInvalid, err.Error())}, e.options()
+	}
+	// The cause may hold store or system detail so it's logged rather than
+	// kept with the event
+	options := e.options()
+	fields := ioc.Fields{ioc.FieldError: err, "exception_id": fmt.Sprintf("%X", uint64(options.Id()))}
+	if command != nil {
+		fields[ioc.FieldAggregateId] = fmt.Sprintf("%X", uint64(command.GetId()))
+		fields[ioc.FieldMessageType] = command.GetMessageType()
+	}
+	e.deps.Logger().With(fields).Errorf("command faulted")
+	return &Internal{Details: NewDetails(command, ioc.CodeInternal, InternalMessage)}, options
+}
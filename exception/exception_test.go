@@ -0,0 +1,60 @@
+package exception_test
+
+import (
+	"errors"
+	"github.com/xzeus/cqrs"
+	. "github.com/xzeus/cqrs/exception"
+	"github.com/xzeus/cqrs/ioc"
+	. "github.com/xzeus/cqrs/testing"
+	"github.com/xzeus/cqrs/testing/testdeps"
+	"testing"
+)
+
+func Test_Should_round_trip_rejection_details(t *testing.T) {
+	payload, options := NewException(testdeps.NewDependencies()).Reject(nil, ioc.CodeNotFound, "no such [ %s ]", "thing")
+	message := cqrs.NewMessage(options.Id(), options.Version(), 0, cqrs.NoOrigin, payload)
+	Equals(t, E_Rejected, message.GetMessageType(), "should be a rejection")
+	e, ok := AsEvent(message)
+	Assert(t, ok, "should extract the exception event")
+	Equals(t, ioc.CodeNotFound, e.GetDetails().Code, "should carry the code")
+	Equals(t, "no such [ thing ]", e.GetDetails().Message, "should format the message")
+}
+
+func Test_Should_classify_faults_by_kind(t *testing.T) {
+	conflict := cqrs.NewHandlerError(cqrs.ErrConflict, Uri, 1, errors.New("stale"))
+	payload, _ := NewException(testdeps.NewDependencies()).Fault(nil, conflict)
+	_, ok := payload.(*Conflict)
+	Assert(t, ok, "should produce a conflict event")
+	fault := cqrs.NewHandlerError(cqrs.ErrHandlerFault, Uri, 1, nil)
+	payload, _ = NewException(testdeps.NewDependencies()).Fault(nil, fault)
+	Equals(t, ioc.CodeInternal, payload.(Event).GetDetails().Code, "should produce an internal event")
+}
+
+func Test_Should_log_internal_fault_cause_rather_than_keep_it(t *testing.T) {
+	deps := testdeps.NewDependencies()
+	command := cqrs.NewMessage(0x2A, 0, 0, cqrs.NoOrigin, &Rejected{})
+	fault := cqrs.NewHandlerError(cqrs.ErrStoreUnavailable, Uri, 1, errors.New("dial tcp 10.0.0.7:5432"))
+	payload, _ := NewException(deps).Fault(command, fault)
+	Equals(t, InternalMessage, payload.(Event).GetDetails().Message, "should keep a generic message")
+	entries := deps.Mock_Logger.Entries
+	Equals(t, 1, len(entries), "should log the fault")
+	Equals(t, fault, entries[0].Fields[ioc.FieldError], "should log the cause")
+	Equals(t, "2A", entries[0].Fields[ioc.FieldAggregateId], "should log the command id")
+}
+
+type fixedCrypto struct {
+	ioc.Crypto
+}
+
+func (fixedCrypto) RandInt64() int64 { return 0x37 }
+
+type fixedDependencies struct {
+	*testdeps.Dependencies
+}
+
+func (fixedDependencies) Crypto() ioc.Crypto { return fixedCrypto{} }
+
+func Test_Should_draw_event_ids_from_deps(t *testing.T) {
+	_, options := NewException(fixedDependencies{testdeps.NewDependencies()}).Error("failed")
+	Equals(t, int64(0x37), options.Id(), "should use the injected crypto")
+}
@@ -0,0 +1,104 @@
+package cqrs
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	domain_name_pattern    = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_\-]*$`)
+	domain_version_pattern = regexp.MustCompile(`^[vV](\d+)(?:[._](\d+))?(?:[._](\d+))?$`)
+)
+
+// SemanticVersion is the [ major.minor.patch ] version parsed from the last
+// segment of a domain uri such as v1, v1_2 or v1.2.3
+type SemanticVersion struct {
+	Major int `json:"major"`
+	Minor int `json:"minor"`
+	Patch int `json:"patch"`
+}
+
+func (v SemanticVersion) String() string {
+	return fmt.Sprintf("v%d.%d.%d", v.Major, v.Minor, v.Patch)
+}
+
+// Less orders versions by major, then minor, then patch
+func (v SemanticVersion) Less(o SemanticVersion) bool {
+	if v.Major != o.Major {
+		return v.Major < o.Major
+	}
+	if v.Minor != o.Minor {
+		return v.Minor < o.Minor
+	}
+	return v.Patch < o.Patch
+}
+
+// DomainIdentity is the structured form of a domain uri laid out as
+// [ namespace / name / version ] where the version segment is optional
+// and defaults to v0.0.0
+type DomainIdentity struct {
+	Uri       string          `json:"uri"`
+	Namespace string          `json:"namespace"`
+	Name      string          `json:"name"`
+	Version   SemanticVersion `json:"version"`
+}
+
+// Key identifies the domain independent of it's version so that multiple
+// versions of the same domain can be grouped
+func (i DomainIdentity) Key() string {
+	if i.Namespace == "" {
+		return i.Name
+	}
+	return i.Namespace + "/" + i.Name
+}
+
+func (i DomainIdentity) String() string {
+	return i.Name + "@" + i.Version.String()
+}
+
+// ParseDomainUri validates the uri and splits it into it's namespace, name
+// and version components, a scheme such as https:// isn't part of the
+// namespace and is dropped
+func ParseDomainUri(uri string) (identity DomainIdentity, err error) {
+	identity.Uri = uri
+	if uri == "" {
+		return identity, invalidDomainUri(uri)
+	}
+	if i := strings.Index(uri, "://"); i >= 0 {
+		uri = uri[i+3:]
+	}
+	segments := strings.Split(strings.TrimSuffix(uri, "/"), "/")
+	for _, segment := range segments {
+		if segment == "" || strings.ContainsAny(segment, " \t\r\n?#") {
+			return identity, invalidDomainUri(segment)
+		}
+	}
+	if m := domain_version_pattern.FindStringSubmatch(segments[len(segments)-1]); m != nil {
+		if len(segments) < 2 { // A version with no name
+			return identity, invalidDomainUri(segments[0])
+		}
+		parts := []*int{&identity.Version.Major, &identity.Version.Minor, &identity.Version.Patch}
+		for i, part := range m[1:] {
+			if part == "" {
+				continue
+			}
+			if *parts[i], err = strconv.Atoi(part); err != nil {
+				return identity, invalidDomainUri(segments[len(segments)-1])
+			}
+		}
+		segments = segments[:len(segments)-1]
+	}
+	identity.Name = segments[len(segments)-1]
+	if !domain_name_pattern.MatchString(identity.Name) {
+		return identity, invalidDomainUri(identity.Name)
+	}
+	identity.Namespace = strings.Join(segments[:len(segments)-1], "/")
+	return
+}
+
+// invalidDomainUri wraps ErrInvalidDomainUri with the offending segment
+func invalidDomainUri(segment string) error {
+	return fmt.Errorf("%w [ %s ]", ErrInvalidDomainUri, segment)
+}
@@ -0,0 +1,40 @@
+package cqrs_test
+
+import (
+	"errors"
+	"github.com/xzeus/cqrs"
+	. "github.com/xzeus/cqrs/testing"
+	"testing"
+)
+
+var domain_uri_values = []struct {
+	uri      string
+	expected cqrs.DomainIdentity
+}{
+	{"github.com/xzeus/app/users/v1_2", cqrs.DomainIdentity{Namespace: "github.com/xzeus/app", Name: "users", Version: cqrs.SemanticVersion{1, 2, 0}}},
+	{"github.com/xzeus/app/users/v2.0.1", cqrs.DomainIdentity{Namespace: "github.com/xzeus/app", Name: "users", Version: cqrs.SemanticVersion{2, 0, 1}}},
+	{"github.com/xzeus/app/users", cqrs.DomainIdentity{Namespace: "github.com/xzeus/app", Name: "users"}},
+	{"users/v3", cqrs.DomainIdentity{Name: "users", Version: cqrs.SemanticVersion{3, 0, 0}}},
+	{"https://github.com/xzeus/app/users/v1", cqrs.DomainIdentity{Namespace: "github.com/xzeus/app", Name: "users", Version: cqrs.SemanticVersion{1, 0, 0}}},
+}
+
+func Test_Should_parse_domain_uri(t *testing.T) {
+	for _, e := range domain_uri_values {
+		e.expected.Uri = e.uri
+		identity, err := cqrs.ParseDomainUri(e.uri)
+		Ok(t, err)
+		Equals(t, e.expected, identity, "Expected identity for [ %s ]", e.uri)
+	}
+}
+
+func Test_Should_reject_invalid_domain_uri(t *testing.T) {
+	for _, uri := range []string{"", "v1", "github.com//users/v1", "github.com/app/users v1", "github.com/app/9users"} {
+		_, err := cqrs.ParseDomainUri(uri)
+		Assert(t, errors.Is(err, cqrs.ErrInvalidDomainUri), "Expected [ %s ] to be rejected", uri)
+	}
+}
+
+func Test_Should_name_the_invalid_domain_uri_segment(t *testing.T) {
+	_, err := cqrs.ParseDomainUri("github.com/app/9users/v1")
+	Equals(t, "invalid domain uri [ 9users ]", err.Error(), "should name the segment")
+}
@@ -1,5 +1,10 @@
 package ioc
 
+import (
+	"context"
+	"io"
+)
+
 type BlobStoreReader interface {
 	Get(string, int64, interface{}) error
 }
@@ -14,3 +19,15 @@ type BlobStoreReaderWriter interface {
 	BlobStoreReader
 	BlobStoreWriter
 }
+
+// BlobStoreContexter is implemented by blob stores which can bind their
+// calls to the cancellation and deadline of a context
+type BlobStoreContexter interface {
+	WithContext(ctx context.Context) BlobStoreReaderWriter
+}
+
+// BlobStoreStreamer is implemented by blob stores which can read a blob
+// without loading it whole into memory
+type BlobStoreStreamer interface {
+	Open(string, int64) (io.ReadCloser, error)
+}
@@ -1,7 +1,9 @@
 package ioc
 
 import (
+	"context"
 	"errors"
+	"time"
 )
 
 var (
@@ -21,3 +23,15 @@ type CacheStoreReaderWriter interface {
 	CacheStoreReader
 	CacheStoreWriter
 }
+
+// CacheStoreContexter is implemented by cache stores which can bind their
+// calls to the cancellation and deadline of a context
+type CacheStoreContexter interface {
+	WithContext(ctx context.Context) CacheStoreReaderWriter
+}
+
+// CacheStoreExpirer is implemented by cache stores which can drop a value
+// once it's ttl has passed
+type CacheStoreExpirer interface {
+	SetExpiring(key string, data interface{}, ttl time.Duration) error
+}
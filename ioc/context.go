@@ -0,0 +1,109 @@
+package ioc
+
+import (
+	"context"
+)
+
+// ContextDependencies are dependencies bound to a context, the stores and
+// http client which implement their Contexter interface are bound as well
+type ContextDependencies interface {
+	Dependencies
+	Context() context.Context
+}
+
+// DependenciesContexter is implemented by dependencies that bind themselves
+// to a context, WithContext then keeps their concrete type so handlers can
+// still assert it
+type DependenciesContexter interface {
+	WithContext(ctx context.Context) ContextDependencies
+}
+
+type contextDependencies struct {
+	Dependencies
+	ctx context.Context
+}
+
+// WithContext binds the dependencies to ctx, replacing any context they were
+// previously bound to, unless they implement DependenciesContexter they're
+// wrapped and Unwrap recovers their concrete type
+func WithContext(ctx context.Context, deps Dependencies) ContextDependencies {
+	if c, ok := deps.(*contextDependencies); ok {
+		deps = c.Dependencies
+	}
+	if c, ok := deps.(DependenciesContexter); ok {
+		return c.WithContext(ctx)
+	}
+	return &contextDependencies{
+		Dependencies: deps,
+		ctx:          ctx,
+	}
+}
+
+// Unwrap returns the dependencies underneath any context binding so that
+// their concrete type can be asserted
+func Unwrap(deps Dependencies) Dependencies {
+	for {
+		c, ok := deps.(*contextDependencies)
+		if !ok {
+			return deps
+		}
+		deps = c.Dependencies
+	}
+}
+
+func (d *contextDependencies) Unwrap() Dependencies {
+	return d.Dependencies
+}
+
+// Context returns the context the dependencies are bound to or the
+// background context when they aren't
+func Context(deps interface{}) context.Context {
+	if c, ok := deps.(ContextDependencies); ok {
+		return c.Context()
+	}
+	return context.Background()
+}
+
+func (d *contextDependencies) Context() context.Context {
+	return d.ctx
+}
+
+func (d *contextDependencies) BlobStore() BlobStoreReaderWriter {
+	s := d.Dependencies.BlobStore()
+	if c, ok := s.(BlobStoreContexter); ok {
+		return c.WithContext(d.ctx)
+	}
+	return s
+}
+
+func (d *contextDependencies) CacheStore() CacheStoreReaderWriter {
+	s := d.Dependencies.CacheStore()
+	if c, ok := s.(CacheStoreContexter); ok {
+		return c.WithContext(d.ctx)
+	}
+	return s
+}
+
+func (d *contextDependencies) DataStore() DataStoreReaderWriter {
+	s := d.Dependencies.DataStore()
+	if c, ok := s.(DataStoreContexter); ok {
+		return c.WithContext(d.ctx)
+	}
+	return s
+}
+
+func (d *contextDependencies) EventStore() EventStoreReaderWriter {
+	s := d.Dependencies.EventStore()
+	if c, ok := s.(EventStoreContexter); ok {
+		return c.WithContext(d.ctx)
+	}
+	return s
+}
+
+func (d *contextDependencies) HttpClient() HttpClient {
+	s := d.Dependencies.HttpClient()
+	if c, ok := s.(HttpClientContexter); ok {
+		return c.WithContext(d.ctx)
+	}
+	return s
+}
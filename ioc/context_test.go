@@ -0,0 +1,44 @@
+package ioc_test
+
+import (
+	"context"
+	. "github.com/xzeus/cqrs/ioc"
+	. "github.com/xzeus/cqrs/testing"
+	"github.com/xzeus/cqrs/testing/testdeps"
+	"testing"
+)
+
+func Test_Should_bind_event_store_to_context(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	deps := WithContext(ctx, testdeps.NewDependencies())
+	Equals(t, ctx, Context(deps), "should expose the bound context")
+	_, err := deps.EventStore().GetAggregateEvents(1, 1, 0)
+	Ok(t, err)
+	cancel()
+	_, err = deps.EventStore().GetAggregateEvents(1, 1, 0)
+	Equals(t, context.Canceled, err, "should fail once the context is cancelled")
+	_, ok := Unwrap(deps).(*testdeps.Dependencies)
+	Assert(t, ok, "should unwrap the concrete dependencies")
+}
+
+func Test_Should_default_to_background_context(t *testing.T) {
+	Equals(t, context.Background(), Context(testdeps.NewDependencies()), "should use the background context")
+}
+
+type boundDeps struct {
+	*testdeps.Dependencies
+	ctx context.Context
+}
+
+func (d *boundDeps) WithContext(ctx context.Context) ContextDependencies {
+	return &boundDeps{d.Dependencies, ctx}
+}
+
+func (d *boundDeps) Context() context.Context { return d.ctx }
+
+func Test_Should_keep_dependencies_bound_by_themselves(t *testing.T) {
+	ctx := context.WithValue(context.Background(), "key", "value")
+	deps, ok := WithContext(ctx, &boundDeps{Dependencies: testdeps.NewDependencies()}).(*boundDeps)
+	Assert(t, ok, "should keep the concrete type")
+	Equals(t, ctx, Context(deps), "should bind the context")
+}
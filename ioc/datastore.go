@@ -1,6 +1,7 @@
 package ioc
 
 import (
+	"context"
 	"errors"
 )
 
@@ -37,6 +38,12 @@ type DataStoreReaderWriter interface {
 	DataStoreWriter
 }
 
+// DataStoreContexter is implemented by data stores which can bind their
+// calls to the cancellation and deadline of a context
+type DataStoreContexter interface {
+	WithContext(ctx context.Context) DataStoreReaderWriter
+}
+
 type DataStoreQuerier interface {
 	ToQuery() *DataStoreQuery
 }
@@ -80,6 +87,7 @@ type DataStoreQuery struct {
 	OrderBy     []string
 	Limit       int
 	Offset      int
+	Cursor      string // Resumes after a previous query, used instead of Offset
 }
 
 func NewDataStoreQuery(kind string) DataStoreFilterer {
@@ -129,3 +137,17 @@ func (query *DataStoreQuery) Skip(offset int) interface{} {
 	query.Offset = offset
 	return query
 }
+
+// StartAt resumes the query from a cursor returned by ExecQueryCursor
+func (query *DataStoreQuery) StartAt(cursor string) *DataStoreQuery {
+	query.Cursor = cursor
+	return query
+}
+
+// DataStoreCursorReader is implemented by data stores which can resume a
+// query from a cursor rather than skipping through an offset
+type DataStoreCursorReader interface {
+	// ExecQueryCursor returns the cursor after the last result, empty when
+	// there are no more
+	ExecQueryCursor(query DataStoreQuerier, data interface{}) (next string, err error)
+}
@@ -1,6 +1,7 @@
 package ioc
 
 import (
+	"context"
 	"errors"
 	"github.com/xzeus/cqrs"
 )
@@ -36,3 +37,15 @@ type EventStoreReaderWriter interface {
 	EventStoreReader
 	EventStoreWriter
 }
+
+// EventStoreContexter is implemented by event stores which can bind their
+// calls to the cancellation and deadline of a context
+type EventStoreContexter interface {
+	WithContext(ctx context.Context) EventStoreReaderWriter
+}
+
+// EventStorePinger is implemented by event stores which can cheaply check
+// that they are reachable
+type EventStorePinger interface {
+	Ping(ctx context.Context) error
+}
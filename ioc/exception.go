@@ -4,7 +4,24 @@ import (
 	"github.com/xzeus/cqrs"
 )
 
+// Codes carried by error events to classify the failure, they match the
+// http status a client would expect
+const (
+	CodeInvalid   = 400
+	CodeForbidden = 403
+	CodeNotFound  = 404
+	CodeConflict  = 409
+	CodeInternal  = 500
+)
+
 type Exception interface {
 	Error(message string, args ...interface{}) (cqrs.MessageDefiner, *cqrs.MessageOptionsDef)
 	Panic(message string, args ...interface{}) (cqrs.MessageDefiner, *cqrs.MessageOptionsDef)
+	// Invalid produces the event published when a command fails validation
+	Invalid(command cqrs.Message, errs cqrs.ValidationErrors) (cqrs.MessageDefiner, *cqrs.MessageOptionsDef)
+	// Reject produces the event published when a handler refuses a command
+	Reject(command cqrs.Message, code int, message string, args ...interface{}) (cqrs.MessageDefiner, *cqrs.MessageOptionsDef)
+	// Fault produces the event published when a command fails with a
+	// cqrs.HandlerError
+	Fault(command cqrs.Message, err error) (cqrs.MessageDefiner, *cqrs.MessageOptionsDef)
 }
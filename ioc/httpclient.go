@@ -1,6 +1,7 @@
 package ioc
 
 import (
+	"context"
 	"net/http"
 )
 
@@ -11,3 +12,9 @@ type HttpClient interface {
 	Exec(action string, data map[string]string) ([]byte, error)
 	Json(action string, data map[string]string, result interface{}) ([]byte, error)
 }
+
+// HttpClientContexter is implemented by clients which can make their
+// requests with a context
+type HttpClientContexter interface {
+	WithContext(ctx context.Context) HttpClient
+}
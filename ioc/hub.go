@@ -0,0 +1,68 @@
+package ioc
+
+import (
+	"github.com/xzeus/cqrs"
+	"sync"
+)
+
+// SubscriberBuffer is how many messages a subscriber may fall behind by
+// before it's dropped
+const SubscriberBuffer = 64
+
+type subscription struct {
+	filter   func(cqrs.Message) bool
+	messages chan cqrs.Message
+}
+
+// PublisherHub fans published messages out to in process subscribers after
+// passing them on to the next publisher
+type PublisherHub struct {
+	sync.Mutex
+	next          Publisher
+	subscriptions map[int]*subscription
+	sequence      int
+}
+
+// NewPublisherHub wraps the next publisher, which may be nil
+func NewPublisherHub(next Publisher) *PublisherHub {
+	return &PublisherHub{
+		next:          next,
+		subscriptions: make(map[int]*subscription),
+	}
+}
+
+func (h *PublisherHub) Publish(message cqrs.Message) {
+	if h.next != nil {
+		h.next.Publish(message)
+	}
+	h.Lock()
+	defer h.Unlock()
+	for id, s := range h.subscriptions {
+		if s.filter != nil && !s.filter(message) {
+			continue
+		}
+		select {
+		case s.messages <- message:
+		default: // Too slow so close it rather than silently skip messages
+			delete(h.subscriptions, id)
+			close(s.messages)
+		}
+	}
+}
+
+func (h *PublisherHub) Subscribe(filter func(cqrs.Message) bool) (<-chan cqrs.Message, func()) {
+	h.Lock()
+	defer h.Unlock()
+	h.sequence++
+	id := h.sequence
+	s := &subscription{filter: filter, messages: make(chan cqrs.Message, SubscriberBuffer)}
+	h.subscriptions[id] = s
+	return s.messages, func() {
+		h.Lock()
+		defer h.Unlock()
+		if _, found := h.subscriptions[id]; found {
+			delete(h.subscriptions, id)
+			close(s.messages)
+		}
+	}
+}
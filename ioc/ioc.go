@@ -12,8 +12,10 @@ type ServiceDependencies interface {
 	Exception() Exception
 	HttpClient() HttpClient
 	Logger() Logger
+	Metrics() Metrics
 	Publisher() Publisher
 	Time() Time
+	Tracer() Tracer
 }
 
 type Dependencies interface {
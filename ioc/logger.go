@@ -1,5 +1,137 @@
 package ioc
 
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+type LogLevel int
+
+const (
+	LevelDebug LogLevel = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+func (l LogLevel) String() string {
+	switch l {
+	case LevelDebug:
+		return "debug"
+	case LevelInfo:
+		return "info"
+	case LevelWarn:
+		return "warn"
+	}
+	return "error"
+}
+
+// Field keys shared by the domain and apiserver log entries
+const (
+	FieldDomain      = "domain"
+	FieldAggregateId = "aggregate_id"
+	FieldVersion     = "version"
+	FieldMessageType = "message_type"
+	FieldMessage     = "message_name"
+	FieldServiceKey  = "service_key"
+	FieldPath        = "path"
+	FieldError       = "error"
+	FieldStack       = "stack"
+)
+
+// Fields are the key-value pairs attached to every entry written by a logger
+type Fields map[string]interface{}
+
 type Logger interface {
+	Debugf(message string, args ...interface{})
 	Infof(message string, args ...interface{})
+	Warnf(message string, args ...interface{})
+	Errorf(message string, args ...interface{})
+	// With returns a logger that adds the fields to each entry, fields with
+	// the same key replace the existing value
+	With(fields Fields) Logger
+}
+
+type jsonLogger struct {
+	mutex  *sync.Mutex
+	writer io.Writer
+	level  LogLevel
+	fields Fields
+	now    func() time.Time
+}
+
+// NewJsonLogger writes each entry at or above level as a single line json
+// object with ts, level and msg keys alongside the logger's fields
+func NewJsonLogger(w io.Writer, level LogLevel) Logger {
+	return &jsonLogger{
+		mutex:  &sync.Mutex{},
+		writer: w,
+		level:  level,
+		fields: Fields{},
+		now:    time.Now,
+	}
+}
+
+func (l *jsonLogger) Debugf(message string, args ...interface{}) {
+	l.write(LevelDebug, message, args)
+}
+
+func (l *jsonLogger) Infof(message string, args ...interface{}) {
+	l.write(LevelInfo, message, args)
+}
+
+func (l *jsonLogger) Warnf(message string, args ...interface{}) {
+	l.write(LevelWarn, message, args)
+}
+
+func (l *jsonLogger) Errorf(message string, args ...interface{}) {
+	l.write(LevelError, message, args)
+}
+
+func (l *jsonLogger) With(fields Fields) Logger {
+	merged := make(Fields, len(l.fields)+len(fields))
+	for k, v := range l.fields {
+		merged[k] = v
+	}
+	for k, v := range fields {
+		merged[k] = v
+	}
+	return &jsonLogger{
+		mutex:  l.mutex,
+		writer: l.writer,
+		level:  l.level,
+		fields: merged,
+		now:    l.now,
+	}
+}
+
+func (l *jsonLogger) write(level LogLevel, message string, args []interface{}) {
+	if level < l.level {
+		return
+	}
+	entry := make(map[string]interface{}, len(l.fields)+3)
+	for k, v := range l.fields {
+		if err, ok := v.(error); ok { // Errors don't marshal their message
+			v = err.Error()
+		}
+		entry[k] = v
+	}
+	entry["ts"] = l.now().UTC().Format(time.RFC3339Nano)
+	entry["level"] = level.String()
+	entry["msg"] = fmt.Sprintf(message, args...)
+	data, err := json.Marshal(entry)
+	if err != nil {
+		data, _ = json.Marshal(map[string]string{
+			"ts":    entry["ts"].(string),
+			"level": level.String(),
+			"msg":   entry["msg"].(string),
+			"error": err.Error(),
+		})
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.writer.Write(append(data, '\n'))
 }
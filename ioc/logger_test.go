@@ -0,0 +1,32 @@
+package ioc_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	. "github.com/xzeus/cqrs/ioc"
+	. "github.com/xzeus/cqrs/testing"
+	"strings"
+	"testing"
+)
+
+func Test_Should_write_json_entries_with_fields(t *testing.T) {
+	buffer := new(bytes.Buffer)
+	log := NewJsonLogger(buffer, LevelDebug).With(Fields{FieldDomain: "test/v1"})
+	log.With(Fields{FieldError: errors.New("boom")}).Errorf("failed [ %d ]", 1)
+	entry := make(map[string]interface{})
+	Ok(t, json.Unmarshal(buffer.Bytes(), &entry))
+	Equals(t, "error", entry["level"], "should record the level")
+	Equals(t, "failed [ 1 ]", entry["msg"], "should format the message")
+	Equals(t, "test/v1", entry[FieldDomain], "should inherit parent fields")
+	Equals(t, "boom", entry[FieldError], "should write the error message")
+}
+
+func Test_Should_skip_entries_below_level(t *testing.T) {
+	buffer := new(bytes.Buffer)
+	log := NewJsonLogger(buffer, LevelWarn)
+	log.Debugf("debug")
+	log.Infof("info")
+	log.Warnf("warn")
+	Equals(t, 1, strings.Count(buffer.String(), "\n"), "should only write the warning")
+}
@@ -0,0 +1,209 @@
+package ioc
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Metric names recorded by the domains and apiserver packages
+const (
+	MetricCommands            = "cqrs_commands_total"
+	MetricCommandSeconds      = "cqrs_command_seconds"
+	MetricHydrateSeconds      = "cqrs_command_hydrate_seconds"
+	MetricEventsReplayed      = "cqrs_command_events_replayed"
+	MetricAppendSeconds       = "cqrs_event_append_seconds"
+	MetricEventHandlers       = "cqrs_event_handlers_total"
+	MetricEventHandlerLag     = "cqrs_event_handler_lag_seconds"
+	MetricEventHandlerSeconds = "cqrs_event_handler_seconds"
+	MetricHttpRequests        = "http_requests_total"
+	MetricHttpRequestSeconds  = "http_request_seconds"
+)
+
+var (
+	// DefaultBuckets are the upper bounds used for latency histograms
+	DefaultBuckets = []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+	// CountBuckets are the upper bounds used for histograms of counts
+	CountBuckets = []float64{0, 1, 5, 10, 50, 100, 500, 1000, 5000, 10000}
+)
+
+type Labels map[string]string
+
+type Metrics interface {
+	// Add increments the counter by value
+	Add(name string, value float64, labels Labels)
+	// Observe records the value in the histogram
+	Observe(name string, value float64, labels Labels)
+}
+
+// MetricsWriter is implemented by metrics that can expose their current
+// values in the prometheus text format
+type MetricsWriter interface {
+	WritePrometheus(w io.Writer) error
+}
+
+type metricSeries struct {
+	labels  string
+	value   float64
+	buckets []uint64
+	sum     float64
+	count   uint64
+}
+
+type metricFamily struct {
+	histogram bool
+	bounds    []float64
+	series    map[string]*metricSeries
+}
+
+// MetricsRegistry keeps counters and histograms in memory
+type MetricsRegistry struct {
+	sync.Mutex
+	families map[string]*metricFamily
+	bounds   map[string][]float64
+}
+
+func NewMetricsRegistry() *MetricsRegistry {
+	return &MetricsRegistry{
+		families: make(map[string]*metricFamily),
+		bounds: map[string][]float64{
+			MetricEventsReplayed: CountBuckets,
+		},
+	}
+}
+
+// SetBuckets overrides the bucket upper bounds of a histogram which hasn't
+// yet been observed
+func (r *MetricsRegistry) SetBuckets(name string, bounds ...float64) {
+	r.Lock()
+	defer r.Unlock()
+	r.bounds[name] = bounds
+}
+
+func (r *MetricsRegistry) Add(name string, value float64, labels Labels) {
+	r.Lock()
+	defer r.Unlock()
+	r.series(name, false, labels).value += value
+}
+
+func (r *MetricsRegistry) Observe(name string, value float64, labels Labels) {
+	r.Lock()
+	defer r.Unlock()
+	f := r.family(name, true)
+	s := r.series(name, true, labels)
+	for i, bound := range f.bounds {
+		if value <= bound {
+			s.buckets[i]++
+		}
+	}
+	s.sum += value
+	s.count++
+}
+
+func (r *MetricsRegistry) family(name string, histogram bool) *metricFamily {
+	f, found := r.families[name]
+	if !found {
+		f = &metricFamily{
+			histogram: histogram,
+			series:    make(map[string]*metricSeries),
+		}
+		if histogram {
+			if f.bounds = r.bounds[name]; f.bounds == nil {
+				f.bounds = DefaultBuckets
+			}
+		}
+		r.families[name] = f
+	}
+	return f
+}
+
+func (r *MetricsRegistry) series(name string, histogram bool, labels Labels) *metricSeries {
+	f := r.family(name, histogram)
+	key := formatLabels(labels)
+	s, found := f.series[key]
+	if !found {
+		s = &metricSeries{
+			labels:  key,
+			buckets: make([]uint64, len(f.bounds)),
+		}
+		f.series[key] = s
+	}
+	return s
+}
+
+// WritePrometheus writes every metric in the prometheus text exposition format
+func (r *MetricsRegistry) WritePrometheus(w io.Writer) (err error) {
+	r.Lock()
+	defer r.Unlock()
+	names := make([]string, 0, len(r.families))
+	for name, _ := range r.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	b := new(strings.Builder)
+	for _, name := range names {
+		f := r.families[name]
+		keys := make([]string, 0, len(f.series))
+		for key, _ := range f.series {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		if !f.histogram {
+			fmt.Fprintf(b, "# TYPE %s counter\n", name)
+			for _, key := range keys {
+				fmt.Fprintf(b, "%s%s %s\n", name, braces(key), formatFloat(f.series[key].value))
+			}
+			continue
+		}
+		fmt.Fprintf(b, "# TYPE %s histogram\n", name)
+		for _, key := range keys {
+			s := f.series[key]
+			for i, bound := range f.bounds {
+				fmt.Fprintf(b, "%s_bucket%s %d\n", name, braces(joinLabels(key, "le=\""+formatFloat(bound)+"\"")), s.buckets[i])
+			}
+			fmt.Fprintf(b, "%s_bucket%s %d\n", name, braces(joinLabels(key, "le=\"+Inf\"")), s.count)
+			fmt.Fprintf(b, "%s_sum%s %s\n", name, braces(key), formatFloat(s.sum))
+			fmt.Fprintf(b, "%s_count%s %d\n", name, braces(key), s.count)
+		}
+	}
+	_, err = io.WriteString(w, b.String())
+	return
+}
+
+func formatLabels(labels Labels) string {
+	keys := make([]string, 0, len(labels))
+	for k, _ := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, len(keys))
+	for i, k := range keys {
+		v := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(labels[k])
+		pairs[i] = fmt.Sprintf("%s=\"%s\"", k, v)
+	}
+	return strings.Join(pairs, ",")
+}
+
+func joinLabels(a, b string) string {
+	if a == "" {
+		return b
+	}
+	return a + "," + b
+}
+
+func braces(labels string) string {
+	if labels == "" {
+		return ""
+	}
+	return "{" + labels + "}"
+}
+
+func formatFloat(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return fmt.Sprintf("%g", v)
+}
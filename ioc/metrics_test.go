@@ -0,0 +1,38 @@
+package ioc_test
+
+import (
+	"bytes"
+	. "github.com/xzeus/cqrs/ioc"
+	. "github.com/xzeus/cqrs/testing"
+	"strings"
+	"testing"
+)
+
+func Test_Should_write_counters_in_prometheus_format(t *testing.T) {
+	r := NewMetricsRegistry()
+	r.Add(MetricCommands, 1, Labels{"domain": "a", "result": "accepted"})
+	r.Add(MetricCommands, 2, Labels{"domain": "a", "result": "accepted"})
+	buffer := new(bytes.Buffer)
+	Ok(t, r.WritePrometheus(buffer))
+	Assert(t, strings.Contains(buffer.String(), "# TYPE cqrs_commands_total counter\n"), "should declare the type")
+	Assert(t, strings.Contains(buffer.String(), `cqrs_commands_total{domain="a",result="accepted"} 3`), "should sum the counter [ %s ]", buffer)
+}
+
+func Test_Should_write_histogram_buckets(t *testing.T) {
+	r := NewMetricsRegistry()
+	r.SetBuckets("latency", 1, 2)
+	r.Observe("latency", 0.5, nil)
+	r.Observe("latency", 1.5, nil)
+	r.Observe("latency", 3, nil)
+	buffer := new(bytes.Buffer)
+	Ok(t, r.WritePrometheus(buffer))
+	for _, line := range []string{
+		`latency_bucket{le="1"} 1`,
+		`latency_bucket{le="2"} 2`,
+		`latency_bucket{le="+Inf"} 3`,
+		`latency_sum 5`,
+		`latency_count 3`,
+	} {
+		Assert(t, strings.Contains(buffer.String(), line+"\n"), "should contain [ %s ] in [ %s ]", line, buffer)
+	}
+}
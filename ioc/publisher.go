@@ -9,3 +9,10 @@ type AsyncPublishCallback func(deps Dependencies, service_key string, event_mess
 type Publisher interface {
 	Publish(cqrs.Message)
 }
+
+// Subscriber is implemented by publishers which can also deliver the
+// messages they publish within the process, cancel must be called once the
+// subscriber is done and the channel is closed if it falls behind
+type Subscriber interface {
+	Subscribe(filter func(cqrs.Message) bool) (messages <-chan cqrs.Message, cancel func())
+}
@@ -0,0 +1,418 @@
+package ioc
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var ErrInvalidTraceParent = errors.New("tracer: invalid traceparent")
+
+// TraceParentHeader is the W3C trace context header carrying a SpanContext
+const TraceParentHeader = "traceparent"
+
+// SpanContext identifies a span within a trace, the zero value has no trace
+type SpanContext struct {
+	TraceId [16]byte
+	SpanId  [8]byte
+	Sampled bool
+}
+
+func (c SpanContext) IsValid() bool {
+	return c.TraceId != [16]byte{} && c.SpanId != [8]byte{}
+}
+
+// TraceParent formats the context as a version 00 W3C traceparent value
+func (c SpanContext) TraceParent() string {
+	if !c.IsValid() {
+		return ""
+	}
+	flags := "00"
+	if c.Sampled {
+		flags = "01"
+	}
+	return fmt.Sprintf("00-%s-%s-%s", hex.EncodeToString(c.TraceId[:]), hex.EncodeToString(c.SpanId[:]), flags)
+}
+
+// ParseTraceParent reads a W3C traceparent value, an empty value returns
+// the zero SpanContext without error
+func ParseTraceParent(value string) (c SpanContext, err error) {
+	if value == "" {
+		return
+	}
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return SpanContext{}, ErrInvalidTraceParent
+	}
+	if parts[0] == "00" && len(parts) != 4 {
+		return SpanContext{}, ErrInvalidTraceParent
+	}
+	var flags []byte
+	if _, err = hex.Decode(c.TraceId[:], []byte(parts[1])); err != nil {
+		return SpanContext{}, ErrInvalidTraceParent
+	}
+	if _, err = hex.Decode(c.SpanId[:], []byte(parts[2])); err != nil {
+		return SpanContext{}, ErrInvalidTraceParent
+	}
+	if flags, err = hex.DecodeString(parts[3]); err != nil {
+		return SpanContext{}, ErrInvalidTraceParent
+	}
+	if !c.IsValid() {
+		return SpanContext{}, ErrInvalidTraceParent
+	}
+	c.Sampled = flags[0]&1 == 1
+	return
+}
+
+type Span interface {
+	Context() SpanContext
+	SetAttributes(Fields)
+	SetError(error)
+	End()
+}
+
+type Tracer interface {
+	// Start begins a span as a child of parent, an invalid parent starts
+	// a new trace
+	Start(name string, parent SpanContext) Span
+}
+
+// SpanData is the completed span handed to an exporter
+type SpanData struct {
+	Service   string      `json:"service"`
+	Name      string      `json:"name"`
+	Context   SpanContext `json:"-"`
+	TraceId   string      `json:"trace_id"`
+	SpanId    string      `json:"span_id"`
+	ParentId  string      `json:"parent_id,omitempty"`
+	Start     time.Time   `json:"start"`
+	End       time.Time   `json:"end"`
+	Attribute Fields      `json:"attributes,omitempty"`
+	Error     string      `json:"error,omitempty"`
+}
+
+type SpanExporter interface {
+	Export(SpanData) error
+}
+
+type tracer struct {
+	service  string
+	exporter SpanExporter
+}
+
+// NewTracer creates spans for the service and hands each to the exporter
+// when it ends, a nil exporter only propagates the context
+func NewTracer(service string, exporter SpanExporter) Tracer {
+	return &tracer{
+		service:  service,
+		exporter: exporter,
+	}
+}
+
+func (t *tracer) Start(name string, parent SpanContext) Span {
+	s := &span{
+		tracer: t,
+		data: SpanData{
+			Service: t.service,
+			Name:    name,
+			Start:   time.Now(),
+		},
+	}
+	c := SpanContext{Sampled: true}
+	if parent.IsValid() {
+		c.TraceId = parent.TraceId
+		c.Sampled = parent.Sampled
+		s.data.ParentId = hex.EncodeToString(parent.SpanId[:])
+	} else {
+		rand.Read(c.TraceId[:])
+	}
+	rand.Read(c.SpanId[:])
+	s.data.Context = c
+	s.data.TraceId = hex.EncodeToString(c.TraceId[:])
+	s.data.SpanId = hex.EncodeToString(c.SpanId[:])
+	return s
+}
+
+type span struct {
+	sync.Mutex
+	tracer *tracer
+	data   SpanData
+	ended  bool
+}
+
+func (s *span) Context() SpanContext {
+	return s.data.Context
+}
+
+func (s *span) SetAttributes(fields Fields) {
+	s.Lock()
+	defer s.Unlock()
+	if s.data.Attribute == nil {
+		s.data.Attribute = Fields{}
+	}
+	for k, v := range fields {
+		s.data.Attribute[k] = v
+	}
+}
+
+func (s *span) SetError(err error) {
+	if err == nil {
+		return
+	}
+	s.Lock()
+	defer s.Unlock()
+	s.data.Error = err.Error()
+}
+
+func (s *span) End() {
+	s.Lock()
+	if s.ended {
+		s.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.End = time.Now()
+	data := s.data
+	s.Unlock()
+	if s.tracer.exporter != nil && data.Context.Sampled {
+		s.tracer.exporter.Export(data)
+	}
+}
+
+type jsonSpanExporter struct {
+	sync.Mutex
+	writer io.Writer
+}
+
+// NewJsonSpanExporter writes each span as a single line json object, used
+// to export spans to a file for tests
+func NewJsonSpanExporter(w io.Writer) SpanExporter {
+	return &jsonSpanExporter{writer: w}
+}
+
+func (e *jsonSpanExporter) Export(data SpanData) error {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	e.Lock()
+	defer e.Unlock()
+	_, err = e.writer.Write(append(b, '\n'))
+	return err
+}
+
+// Bounds of the otlp exporter's queue and batches
+const (
+	OtlpQueueSize  = 2048
+	OtlpBatchSize  = 256
+	OtlpBatchDelay = 2 * time.Second
+)
+
+var (
+	ErrSpanQueueFull  = errors.New("tracer: span queue full")
+	ErrExporterClosed = errors.New("tracer: exporter closed")
+)
+
+// OtlpSpanExporter queues spans and posts them in batches from a background
+// goroutine so that a slow collector never holds up span.End
+type OtlpSpanExporter struct {
+	sync.RWMutex
+	endpoint string
+	client   *http.Client
+	log      Logger
+	queue    chan SpanData
+	flushes  chan chan struct{}
+	done     chan struct{}
+	closed   bool
+	dropped  int64 // Spans dropped since last logged, accessed atomically
+}
+
+// NewOtlpSpanExporter posts spans as OTLP/HTTP json to a collector endpoint
+// such as http://localhost:4318/v1/traces, failures are logged to log and
+// Close must be called to send the spans still queued
+func NewOtlpSpanExporter(endpoint string, client *http.Client, log Logger) *OtlpSpanExporter {
+	if client == nil {
+		client = &http.Client{Timeout: 5 * time.Second}
+	}
+	if log == nil {
+		log = NewJsonLogger(os.Stderr, LevelError)
+	}
+	e := &OtlpSpanExporter{
+		endpoint: endpoint,
+		client:   client,
+		log:      log.With(Fields{"exporter": endpoint}),
+		queue:    make(chan SpanData, OtlpQueueSize),
+		flushes:  make(chan chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go e.run()
+	return e
+}
+
+// Export queues the span, it's dropped when the queue is full
+func (e *OtlpSpanExporter) Export(data SpanData) error {
+	e.RLock()
+	defer e.RUnlock()
+	if e.closed {
+		return ErrExporterClosed
+	}
+	select {
+	case e.queue <- data:
+		return nil
+	default:
+		atomic.AddInt64(&e.dropped, 1)
+		return ErrSpanQueueFull
+	}
+}
+
+// Flush sends the queued spans and waits for the post to complete
+func (e *OtlpSpanExporter) Flush() {
+	c := make(chan struct{})
+	select {
+	case e.flushes <- c:
+		<-c
+	case <-e.done:
+	}
+}
+
+// Close sends the queued spans and stops the background goroutine
+func (e *OtlpSpanExporter) Close() error {
+	e.Lock()
+	if !e.closed {
+		e.closed = true
+		close(e.queue)
+	}
+	e.Unlock()
+	<-e.done
+	return nil
+}
+
+func (e *OtlpSpanExporter) run() {
+	defer close(e.done)
+	ticker := time.NewTicker(OtlpBatchDelay)
+	defer ticker.Stop()
+	batch := make([]SpanData, 0, OtlpBatchSize)
+	send := func() {
+		if dropped := atomic.SwapInt64(&e.dropped, 0); dropped > 0 {
+			e.log.With(Fields{"spans": dropped}).Errorf("dropped spans as the queue was full")
+		}
+		if len(batch) > 0 {
+			if err := e.post(batch); err != nil {
+				e.log.With(Fields{FieldError: err, "spans": len(batch)}).Errorf("unable to export spans")
+			}
+		}
+		batch = batch[:0]
+	}
+	for {
+		select {
+		case data, open := <-e.queue:
+			if !open {
+				send()
+				return
+			}
+			batch = append(batch, data)
+			if len(batch) >= OtlpBatchSize {
+				send()
+			}
+		case <-ticker.C:
+			send()
+		case c := <-e.flushes:
+			for queued := true; queued; {
+				select {
+				case data, open := <-e.queue:
+					if open {
+						batch = append(batch, data)
+					}
+					queued = open
+				default:
+					queued = false
+				}
+			}
+			send()
+			close(c)
+		}
+	}
+}
+
+type otlpValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+func otlpAttributes(fields Fields) []otlpAttribute {
+	r := make([]otlpAttribute, 0, len(fields))
+	for k, v := range fields {
+		r = append(r, otlpAttribute{Key: k, Value: otlpValue{StringValue: fmt.Sprintf("%v", v)}})
+	}
+	return r
+}
+
+func otlpSpan(data SpanData) map[string]interface{} {
+	status := map[string]interface{}{"code": 1} // Ok
+	if data.Error != "" {
+		status = map[string]interface{}{"code": 2, "message": data.Error}
+	}
+	return map[string]interface{}{
+		"traceId":           data.TraceId,
+		"spanId":            data.SpanId,
+		"parentSpanId":      data.ParentId,
+		"name":              data.Name,
+		"kind":              1, // Internal
+		"startTimeUnixNano": fmt.Sprintf("%d", data.Start.UnixNano()),
+		"endTimeUnixNano":   fmt.Sprintf("%d", data.End.UnixNano()),
+		"attributes":        otlpAttributes(data.Attribute),
+		"status":            status,
+	}
+}
+
+// post sends the batch as one request with a resource per service
+func (e *OtlpSpanExporter) post(batch []SpanData) error {
+	services := make([]string, 0)
+	spans := make(map[string][]interface{})
+	for _, data := range batch {
+		if _, found := spans[data.Service]; !found {
+			services = append(services, data.Service)
+		}
+		spans[data.Service] = append(spans[data.Service], otlpSpan(data))
+	}
+	resources := make([]interface{}, len(services))
+	for i, service := range services {
+		resources[i] = map[string]interface{}{
+			"resource": map[string]interface{}{
+				"attributes": otlpAttributes(Fields{"service.name": service}),
+			},
+			"scopeSpans": []interface{}{map[string]interface{}{
+				"scope": map[string]interface{}{"name": "github.com/xzeus/cqrs"},
+				"spans": spans[service],
+			}},
+		}
+	}
+	body := map[string]interface{}{"resourceSpans": resources}
+	b, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	resp, err := e.client.Post(e.endpoint, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("tracer: collector responded [ %d ]", resp.StatusCode)
+	}
+	return nil
+}
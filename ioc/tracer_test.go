@@ -0,0 +1,64 @@
+package ioc_test
+
+import (
+	"bytes"
+	"encoding/json"
+	. "github.com/xzeus/cqrs/ioc"
+	. "github.com/xzeus/cqrs/testing"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func Test_Should_round_trip_traceparent(t *testing.T) {
+	value := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	c, err := ParseTraceParent(value)
+	Ok(t, err)
+	Assert(t, c.Sampled, "should read the sampled flag")
+	Equals(t, value, c.TraceParent(), "should format the same value")
+	_, err = ParseTraceParent("00-00000000000000000000000000000000-00f067aa0ba902b7-01")
+	Equals(t, ErrInvalidTraceParent, err, "should reject an all zero trace id")
+}
+
+func Test_Should_export_child_spans_in_parent_trace(t *testing.T) {
+	buffer := new(bytes.Buffer)
+	tracer := NewTracer("test", NewJsonSpanExporter(buffer))
+	parent := tracer.Start("parent", SpanContext{})
+	child := tracer.Start("child", parent.Context())
+	child.End()
+	parent.End()
+	data := SpanData{}
+	Ok(t, json.NewDecoder(buffer).Decode(&data))
+	Equals(t, "child", data.Name, "should export the first ended span")
+	Equals(t, parent.Context().TraceId, child.Context().TraceId, "should share the trace")
+	Equals(t, parent.Context().TraceParent()[36:52], data.ParentId, "should reference the parent span")
+}
+
+func Test_Should_batch_otlp_spans_and_log_failures(t *testing.T) {
+	posts := make(chan int, 4)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := struct {
+			ResourceSpans []struct {
+				ScopeSpans []struct {
+					Spans []json.RawMessage `json:"spans"`
+				} `json:"scopeSpans"`
+			} `json:"resourceSpans"`
+		}{}
+		json.NewDecoder(r.Body).Decode(&body)
+		posts <- len(body.ResourceSpans[0].ScopeSpans[0].Spans)
+		w.WriteHeader(503)
+	}))
+	defer collector.Close()
+	log := new(bytes.Buffer)
+	exporter := NewOtlpSpanExporter(collector.URL, nil, NewJsonLogger(log, LevelError))
+	tracer := NewTracer("test", exporter)
+	for i := 0; i < 3; i++ {
+		tracer.Start("span", SpanContext{}).End()
+	}
+	exporter.Flush()
+	Equals(t, 3, <-posts, "should post the spans as one batch")
+	Assert(t, strings.Contains(log.String(), "unable to export spans"), "should log the failed post [ %s ]", log)
+	Ok(t, exporter.Close())
+	Equals(t, ErrExporterClosed, exporter.Export(SpanData{}), "should not queue once closed")
+}
@@ -39,6 +39,9 @@ type MessageData struct {
 	MessageType MessageType `datastore:",noindex" json:"_type"`
 	//
 	Data []byte `datastore:",noindex" json:"_data"`
+	// TraceParent carries the W3C trace context of the span which produced
+	// the message across asynchronous hops
+	TraceParent string `datastore:",noindex" json:"_trace,omitempty"`
 }
 
 func (msg MessageData) GetSourceId() int64 {
@@ -90,6 +93,20 @@ func (msg MessageData) GetData() []byte {
 	return msg.Data
 }
 
+func (msg MessageData) GetTraceParent() string {
+	return msg.TraceParent
+}
+
 func (msg MessageData) Reference() *MessageData {
 	return &msg
 }
+
+// WithTraceParent returns a copy of the message carrying the trace context
+func WithTraceParent(message Message, trace_parent string) Message {
+	if message == nil || trace_parent == "" {
+		return message
+	}
+	m := message.Reference()
+	m.TraceParent = trace_parent
+	return m
+}
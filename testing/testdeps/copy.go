@@ -0,0 +1,24 @@
+package testdeps
+
+import (
+	"errors"
+	"reflect"
+)
+
+// copyValue assigns the cached value to the pointer target when the types
+// are compatible, mirroring how a real cache decodes into the provided type
+func copyValue(v interface{}, data interface{}) error {
+	dst := reflect.ValueOf(data)
+	if dst.Kind() != reflect.Ptr || dst.IsNil() {
+		return errors.New("testdeps: cache target must be a non nil pointer")
+	}
+	src := reflect.ValueOf(v)
+	if src.Kind() == reflect.Ptr && src.Type() == dst.Type() {
+		src = src.Elem()
+	}
+	if !src.Type().AssignableTo(dst.Elem().Type()) {
+		return errors.New("testdeps: cached value type mismatch")
+	}
+	dst.Elem().Set(src)
+	return nil
+}
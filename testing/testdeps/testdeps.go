@@ -0,0 +1,435 @@
+// Package testdeps provides in memory ioc.Dependencies for tests, each
+// dependency exposes Mock_ funcs that can be replaced to change behaviour
+package testdeps
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	j "github.com/vizidrix/jose"
+	"github.com/xzeus/cqrs"
+	"github.com/xzeus/cqrs/exception"
+	"github.com/xzeus/cqrs/ioc"
+	"hash/fnv"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var ErrNotImplemented = errors.New("testdeps: not implemented")
+
+type Dependencies struct {
+	Mock_BlobStore  *Mock_BlobStore
+	Mock_CacheStore *Mock_CacheStore
+	Mock_DataStore  *Mock_DataStore
+	Mock_EventStore *Mock_EventStore
+	Mock_Crypto     *Mock_Crypto
+	Mock_Exception  *Mock_Exception
+	Mock_HttpClient *Mock_HttpClient
+	Mock_Logger     *Mock_Logger
+	Mock_Metrics    *ioc.MetricsRegistry
+	Mock_Publisher  *Mock_Publisher
+	Mock_Time       *Mock_Time
+	Mock_Spans      *Mock_SpanExporter
+	Mock_Tracer     ioc.Tracer
+}
+
+func NewDependencies() *Dependencies {
+	spans := &Mock_SpanExporter{}
+	clock := &Mock_Time{}
+	d := &Dependencies{
+		Mock_BlobStore:  &Mock_BlobStore{},
+		Mock_CacheStore: &Mock_CacheStore{values: make(map[string]interface{}), ttls: make(map[string]time.Duration), expires: make(map[string]int64), clock: clock},
+		Mock_DataStore:  &Mock_DataStore{},
+		Mock_EventStore: &Mock_EventStore{streams: make(map[string][]cqrs.Message)},
+		Mock_Crypto:     &Mock_Crypto{},
+		Mock_HttpClient: &Mock_HttpClient{},
+		Mock_Logger:     &Mock_Logger{},
+		Mock_Metrics:    ioc.NewMetricsRegistry(),
+		Mock_Publisher:  &Mock_Publisher{},
+		Mock_Time:       clock,
+		Mock_Spans:      spans,
+		Mock_Tracer:     ioc.NewTracer("testdeps", spans),
+	}
+	d.Mock_Exception = &Mock_Exception{Exception: exception.NewException(d)}
+	return d
+}
+
+func (d *Dependencies) BlobStore() ioc.BlobStoreReaderWriter   { return d.Mock_BlobStore }
+func (d *Dependencies) CacheStore() ioc.CacheStoreReaderWriter { return d.Mock_CacheStore }
+func (d *Dependencies) DataStore() ioc.DataStoreReaderWriter   { return d.Mock_DataStore }
+func (d *Dependencies) EventStore() ioc.EventStoreReaderWriter { return d.Mock_EventStore }
+func (d *Dependencies) Crypto() ioc.Crypto                     { return d.Mock_Crypto }
+func (d *Dependencies) Exception() ioc.Exception               { return d.Mock_Exception }
+func (d *Dependencies) HttpClient() ioc.HttpClient             { return d.Mock_HttpClient }
+func (d *Dependencies) Logger() ioc.Logger                     { return d.Mock_Logger }
+func (d *Dependencies) Metrics() ioc.Metrics                   { return d.Mock_Metrics }
+func (d *Dependencies) Publisher() ioc.Publisher               { return d.Mock_Publisher }
+func (d *Dependencies) Tracer() ioc.Tracer                     { return d.Mock_Tracer }
+func (d *Dependencies) Time() ioc.Time                         { return d.Mock_Time }
+
+// Blob, data and http access aren't simulated, calls fail with ErrNotImplemented
+
+type Mock_BlobStore struct {
+	ioc.BlobStoreReaderWriter
+}
+
+func (m *Mock_BlobStore) Get(string, int64, interface{}) error { return ErrNotImplemented }
+
+type Mock_DataStore struct {
+	ioc.DataStoreReaderWriter
+}
+
+func (m *Mock_DataStore) ExecQuery(ioc.DataStoreQuerier, interface{}) error { return ErrNotImplemented }
+
+type Mock_HttpClient struct {
+	ioc.HttpClient
+}
+
+func (m *Mock_HttpClient) Ptr() *http.Client { return http.DefaultClient }
+func (m *Mock_HttpClient) BaseUri() string   { return "localhost" }
+
+// Mock_CacheStore keeps values in memory as is without serialization,
+// expiring values are dropped once Mock_Time passes their ttl
+
+type Mock_CacheStore struct {
+	sync.Mutex
+	values  map[string]interface{}
+	ttls    map[string]time.Duration
+	expires map[string]int64
+	clock   ioc.Time
+}
+
+func (m *Mock_CacheStore) Get(key string, data interface{}) error {
+	m.Lock()
+	defer m.Unlock()
+	if expires, ok := m.expires[key]; ok && m.clock.Now() >= expires {
+		m.delete(key)
+	}
+	v, found := m.values[key]
+	if !found {
+		return ioc.ErrCacheMiss
+	}
+	switch d := data.(type) {
+	case *interface{}:
+		*d = v
+	default:
+		return copyValue(v, data)
+	}
+	return nil
+}
+
+func (m *Mock_CacheStore) Set(key string, data interface{}) error {
+	m.Lock()
+	defer m.Unlock()
+	m.delete(key)
+	m.values[key] = data
+	return nil
+}
+
+// SetExpiring keeps the value until ttl has passed on Mock_Time, the ttl is
+// kept for TTL
+func (m *Mock_CacheStore) SetExpiring(key string, data interface{}, ttl time.Duration) error {
+	m.Lock()
+	defer m.Unlock()
+	m.values[key] = data
+	m.ttls[key] = ttl
+	m.expires[key] = m.clock.Now() + int64(ttl)
+	return nil
+}
+
+// TTL is the ttl the key was last set with by SetExpiring
+func (m *Mock_CacheStore) TTL(key string) time.Duration {
+	m.Lock()
+	defer m.Unlock()
+	return m.ttls[key]
+}
+
+func (m *Mock_CacheStore) Delete(key string) error {
+	m.Lock()
+	defer m.Unlock()
+	m.delete(key)
+	return nil
+}
+
+func (m *Mock_CacheStore) delete(key string) {
+	delete(m.values, key)
+	delete(m.ttls, key)
+	delete(m.expires, key)
+}
+
+// Mock_EventStore keeps each aggregate's events in order in memory
+
+type Mock_EventStore struct {
+	sync.Mutex
+	ioc.EventStoreReaderWriter
+	streams map[string][]cqrs.Message
+	events  []cqrs.Message // Every stream's events in the order appended
+	Now     func() int64
+}
+
+func streamKey(domain int32, id int64) string {
+	return fmt.Sprintf("%X|%X", uint32(domain), uint64(id))
+}
+
+func (m *Mock_EventStore) GetAggregateEvents(domain int32, id int64, min_version int32) ([]cqrs.Message, error) {
+	m.Lock()
+	defer m.Unlock()
+	r := make([]cqrs.Message, 0)
+	for _, e := range m.streams[streamKey(domain, id)] {
+		if e.GetVersion() >= min_version {
+			r = append(r, e)
+		}
+	}
+	return r, nil
+}
+
+func (m *Mock_EventStore) GetKeyedAggregateEvents(domain int32, key []byte, min_version int32) ([]cqrs.Message, error) {
+	return m.GetAggregateEvents(domain, hash64(key), min_version)
+}
+
+func (m *Mock_EventStore) GetEvent(domain int32, id int64, version int32) (cqrs.Message, error) {
+	m.Lock()
+	defer m.Unlock()
+	for _, e := range m.streams[streamKey(domain, id)] {
+		if e.GetVersion() == version {
+			return e, nil
+		}
+	}
+	return nil, cqrs.ErrNoSuchAggregate
+}
+
+func (m *Mock_EventStore) GetDomainEvents(domain int32, min_ts, max_ts int64) ([]cqrs.Message, error) {
+	m.Lock()
+	defer m.Unlock()
+	r := make([]cqrs.Message, 0)
+	for _, e := range m.events {
+		if e.GetDomainId() == domain && e.GetTimestamp() >= min_ts && (max_ts == 0 || e.GetTimestamp() <= max_ts) {
+			r = append(r, e)
+		}
+	}
+	return r, nil
+}
+
+func (m *Mock_EventStore) AppendEvent(id int64, version int32, origin []cqrs.AggregateHeader, payload cqrs.MessageDefiner) (cqrs.Message, error) {
+	m.Lock()
+	defer m.Unlock()
+	return m.appendEvent(id, version, origin, payload)
+}
+
+// appendEvent is called with the lock held
+func (m *Mock_EventStore) appendEvent(id int64, version int32, origin []cqrs.AggregateHeader, payload cqrs.MessageDefiner) (cqrs.Message, error) {
+	key := streamKey(payload.Domain().Id(), id)
+	if int(version) != len(m.streams[key])+1 {
+		return nil, ioc.ErrStaleEventVersion
+	}
+	ts := time.Now().UnixNano()
+	if m.Now != nil {
+		ts = m.Now()
+	}
+	e := cqrs.NewMessage(id, version, ts, origin, payload)
+	m.streams[key] = append(m.streams[key], e)
+	m.events = append(m.events, e)
+	return e, nil
+}
+
+// WithContext binds the store's reads and appends to ctx, failing them with
+// the context's error once it's done
+func (m *Mock_EventStore) WithContext(ctx context.Context) ioc.EventStoreReaderWriter {
+	return &Mock_ContextEventStore{Mock_EventStore: m, ctx: ctx}
+}
+
+func (m *Mock_EventStore) AppendKeyedEvent(key []byte, origin []cqrs.AggregateHeader, payload cqrs.MessageDefiner) (cqrs.Message, error) {
+	if len(key) == 0 {
+		return nil, ioc.ErrInvalidEventKey
+	}
+	m.Lock()
+	defer m.Unlock()
+	id := hash64(key)
+	version := int32(len(m.streams[streamKey(payload.Domain().Id(), id)])) + 1
+	return m.appendEvent(id, version, origin, payload)
+}
+
+// Mock_Crypto uses fnv hashes and math/rand, token handling must be mocked
+
+type Mock_Crypto struct {
+	Mock_DecodeToken func(m *Mock_Crypto, token []byte, mods ...j.TokenModifier) (*j.TokenDef, error)
+	Mock_EncodeToken func(m *Mock_Crypto, mods ...j.TokenModifier) ([]byte, error)
+}
+
+func (m *Mock_Crypto) DecodeToken(token []byte, mods ...j.TokenModifier) (*j.TokenDef, error) {
+	if m.Mock_DecodeToken == nil {
+		return nil, ErrNotImplemented
+	}
+	return m.Mock_DecodeToken(m, token, mods...)
+}
+
+func (m *Mock_Crypto) EncodeToken(mods ...j.TokenModifier) ([]byte, error) {
+	if m.Mock_EncodeToken == nil {
+		return nil, ErrNotImplemented
+	}
+	return m.Mock_EncodeToken(m, mods...)
+}
+
+func (m *Mock_Crypto) Hash32(key []byte) int32 {
+	h := fnv.New32a()
+	h.Write(key)
+	return int32(h.Sum32())
+}
+
+func (m *Mock_Crypto) Hash64(key []byte) int64     { return hash64(key) }
+func (m *Mock_Crypto) CrcKeyHash(key []byte) int64 { return hash64(key) }
+func (m *Mock_Crypto) RandInt32() int32            { return rand.Int31() }
+func (m *Mock_Crypto) RandInt64() int64            { return rand.Int63() }
+
+func hash64(key []byte) int64 {
+	h := fnv.New64a()
+	h.Write(key)
+	return int64(h.Sum64())
+}
+
+// Mock_Exception produces the exception package's events, Mock_Error
+// replaces the event produced by Error when set
+
+type Mock_Exception struct {
+	ioc.Exception
+	Mock_Error func(message string, args ...interface{}) (cqrs.MessageDefiner, *cqrs.MessageOptionsDef)
+}
+
+func (m *Mock_Exception) Error(message string, args ...interface{}) (cqrs.MessageDefiner, *cqrs.MessageOptionsDef) {
+	if m.Mock_Error == nil {
+		return m.Exception.Error(message, args...)
+	}
+	return m.Mock_Error(message, args...)
+}
+
+// Mock_Logger records each entry with it's level and fields
+
+type Mock_LogEntry struct {
+	Level   ioc.LogLevel
+	Message string
+	Fields  ioc.Fields
+}
+
+type Mock_Logger struct {
+	sync.Mutex
+	Entries []Mock_LogEntry
+	root    *Mock_Logger
+	fields  ioc.Fields
+}
+
+func (m *Mock_Logger) Debugf(message string, args ...interface{}) {
+	m.write(ioc.LevelDebug, message, args)
+}
+
+func (m *Mock_Logger) Infof(message string, args ...interface{}) {
+	m.write(ioc.LevelInfo, message, args)
+}
+
+func (m *Mock_Logger) Warnf(message string, args ...interface{}) {
+	m.write(ioc.LevelWarn, message, args)
+}
+
+func (m *Mock_Logger) Errorf(message string, args ...interface{}) {
+	m.write(ioc.LevelError, message, args)
+}
+
+func (m *Mock_Logger) With(fields ioc.Fields) ioc.Logger {
+	merged := ioc.Fields{}
+	for k, v := range m.fields {
+		merged[k] = v
+	}
+	for k, v := range fields {
+		merged[k] = v
+	}
+	root := m
+	if m.root != nil {
+		root = m.root
+	}
+	return &Mock_Logger{root: root, fields: merged}
+}
+
+func (m *Mock_Logger) write(level ioc.LogLevel, message string, args []interface{}) {
+	root := m
+	if m.root != nil {
+		root = m.root
+	}
+	root.Lock()
+	defer root.Unlock()
+	root.Entries = append(root.Entries, Mock_LogEntry{
+		Level:   level,
+		Message: fmt.Sprintf(message, args...),
+		Fields:  m.fields,
+	})
+}
+
+// Mock_Publisher records each published message
+
+type Mock_Publisher struct {
+	sync.Mutex
+	Published []cqrs.Message
+}
+
+func (m *Mock_Publisher) Publish(message cqrs.Message) {
+	m.Lock()
+	defer m.Unlock()
+	m.Published = append(m.Published, message)
+}
+
+type Mock_Time struct {
+	Mock_Now func() int64
+}
+
+func (m *Mock_Time) Now() int64 {
+	if m.Mock_Now == nil {
+		return time.Now().UnixNano()
+	}
+	return m.Mock_Now()
+}
+
+// Mock_SpanExporter records each ended span
+type Mock_SpanExporter struct {
+	sync.Mutex
+	Spans []ioc.SpanData
+}
+
+func (m *Mock_SpanExporter) Export(data ioc.SpanData) error {
+	m.Lock()
+	defer m.Unlock()
+	m.Spans = append(m.Spans, data)
+	return nil
+}
+
+type Mock_ContextEventStore struct {
+	*Mock_EventStore
+	ctx context.Context
+}
+
+func (m *Mock_ContextEventStore) GetAggregateEvents(domain int32, id int64, min_version int32) ([]cqrs.Message, error) {
+	if err := m.ctx.Err(); err != nil {
+		return nil, err
+	}
+	return m.Mock_EventStore.GetAggregateEvents(domain, id, min_version)
+}
+
+func (m *Mock_ContextEventStore) GetKeyedAggregateEvents(domain int32, key []byte, min_version int32) ([]cqrs.Message, error) {
+	if err := m.ctx.Err(); err != nil {
+		return nil, err
+	}
+	return m.Mock_EventStore.GetKeyedAggregateEvents(domain, key, min_version)
+}
+
+func (m *Mock_ContextEventStore) AppendEvent(id int64, version int32, origin []cqrs.AggregateHeader, payload cqrs.MessageDefiner) (cqrs.Message, error) {
+	if err := m.ctx.Err(); err != nil {
+		return nil, err
+	}
+	return m.Mock_EventStore.AppendEvent(id, version, origin, payload)
+}
+
+func (m *Mock_ContextEventStore) AppendKeyedEvent(key []byte, origin []cqrs.AggregateHeader, payload cqrs.MessageDefiner) (cqrs.Message, error) {
+	if err := m.ctx.Err(); err != nil {
+		return nil, err
+	}
+	return m.Mock_EventStore.AppendKeyedEvent(key, origin, payload)
+}
@@ -0,0 +1,46 @@
+package testdeps_test
+
+import (
+	"github.com/xzeus/cqrs/ioc"
+	. "github.com/xzeus/cqrs/testing"
+	"github.com/xzeus/cqrs/testing/testdeps"
+	"github.com/xzeus/cqrs/testing/testdomain"
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_Should_expire_cache_values_on_mock_time(t *testing.T) {
+	deps := testdeps.NewDependencies()
+	now := time.Unix(1000, 0).UnixNano()
+	deps.Mock_Time.Mock_Now = func() int64 { return now }
+	cache := deps.Mock_CacheStore
+	Ok(t, cache.SetExpiring("a", "value", time.Second))
+	Ok(t, cache.Set("b", "value"))
+	var v interface{}
+	Ok(t, cache.Get("a", &v))
+	now += int64(time.Second)
+	Equals(t, ioc.ErrCacheMiss, cache.Get("a", &v), "should have expired the value")
+	Equals(t, time.Duration(0), cache.TTL("a"), "should have dropped the ttl")
+	Ok(t, cache.Get("b", &v))
+}
+
+func Test_Should_append_concurrent_keyed_events_in_order(t *testing.T) {
+	store := testdeps.NewDependencies().Mock_EventStore
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := store.AppendKeyedEvent([]byte("key"), nil, &testdomain.TestKeyedEvent{})
+			Ok(t, err)
+		}()
+	}
+	wg.Wait()
+	events, err := store.GetKeyedAggregateEvents(testdomain.Domain.Id(), []byte("key"), 0)
+	Ok(t, err)
+	Equals(t, 20, len(events), "should have appended every event")
+	for i, e := range events {
+		Equals(t, int32(i+1), e.GetVersion(), "should append versions in order")
+	}
+}
@@ -0,0 +1,310 @@
+package cqrs
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ValidateTag is the struct tag holding a comma separated list of rules
+//
+//	required      value must not be empty
+//	min=N, max=N  numeric value bounds
+//	len=N         exact length of a string, slice or map
+//	minlen=N      minimum length of a string, slice or map
+//	maxlen=N      maximum length of a string, slice or map
+//	enum=a|b|c    value must be one of the listed values
+//	regex=EXPR    string must match, must be the last rule as EXPR may contain commas
+//
+// Empty strings, slices, maps and nil pointers skip every rule but required
+const ValidateTag = "validate"
+
+// FieldError describes a single rule that a field failed
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// ValidationErrors is the list of every rule failed by a payload
+type ValidationErrors []FieldError
+
+func (errs ValidationErrors) Error() string {
+	r := make([]string, len(errs))
+	for i, e := range errs {
+		r[i] = fmt.Sprintf("%s: %s", e.Field, e.Message)
+	}
+	return "validation failed [ " + strings.Join(r, ", ") + " ]"
+}
+
+type validationRule struct {
+	name  string
+	arg   string
+	num   float64
+	regex *regexp.Regexp
+	enum  []string
+}
+
+type validationField struct {
+	index  int
+	name   string
+	rules  []validationRule
+	nested bool
+}
+
+var validation_cache sync.Map // reflect.Type -> []validationField
+
+// CompileValidation parses and caches the rules of the payload's validate
+// tags so that malformed tags fail when the command is defined rather than
+// when it's first validated
+func CompileValidation(payload interface{}) error {
+	t := reflect.TypeOf(payload)
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		return nil
+	}
+	return compileValidation(t, make(map[reflect.Type]bool))
+}
+
+func compileValidation(t reflect.Type, seen map[reflect.Type]bool) error {
+	if seen[t] { // Recursive types
+		return nil
+	}
+	seen[t] = true
+	fields, err := validationFields(t)
+	if err != nil {
+		return err
+	}
+	for _, f := range fields {
+		if !f.nested {
+			continue
+		}
+		ft := t.Field(f.index).Type
+		for ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		if err := compileValidation(ft, seen); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Validate checks the payload against the rules in it's validate tags and
+// returns nil when all of them pass
+func Validate(payload interface{}) ValidationErrors {
+	v := reflect.ValueOf(payload)
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return nil
+	}
+	var errs ValidationErrors
+	validateStruct(v, "", &errs)
+	return errs
+}
+
+func validateStruct(v reflect.Value, prefix string, errs *ValidationErrors) {
+	fields, err := validationFields(v.Type())
+	if err != nil { // Payload was never compiled by a definition
+		*errs = append(*errs, FieldError{Field: prefix, Rule: ValidateTag, Message: err.Error()})
+		return
+	}
+	for _, f := range fields {
+		fv := v.Field(f.index)
+		name := f.name
+		if prefix != "" && name != "" {
+			name = prefix + "." + name
+		} else if name == "" {
+			name = prefix
+		}
+		for _, rule := range f.rules {
+			if msg, ok := rule.check(fv); !ok {
+				*errs = append(*errs, FieldError{Field: name, Rule: rule.name, Message: msg})
+			}
+		}
+		if f.nested {
+			for fv.Kind() == reflect.Ptr && !fv.IsNil() {
+				fv = fv.Elem()
+			}
+			if fv.Kind() == reflect.Struct {
+				validateStruct(fv, name, errs)
+			}
+		}
+	}
+}
+
+func validationFields(t reflect.Type) ([]validationField, error) {
+	if cached, ok := validation_cache.Load(t); ok {
+		return cached.([]validationField), nil
+	}
+	fields := make([]validationField, 0)
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if sf.PkgPath != "" && !sf.Anonymous { // Unexported, json can't set it either
+			continue
+		}
+		rules, err := parseRules(sf.Tag.Get(ValidateTag))
+		if err != nil {
+			return nil, fmt.Errorf("%s.%s: %s", t.Name(), sf.Name, err)
+		}
+		f := validationField{
+			index: i,
+			name:  fieldName(sf),
+			rules: rules,
+		}
+		if sf.Anonymous {
+			f.name = "" // Promoted fields keep the parent's path
+		}
+		ft := sf.Type
+		for ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		f.nested = ft.Kind() == reflect.Struct
+		if len(f.rules) > 0 || f.nested {
+			fields = append(fields, f)
+		}
+	}
+	validation_cache.Store(t, fields)
+	return fields, nil
+}
+
+func fieldName(sf reflect.StructField) string {
+	if tag := sf.Tag.Get("json"); tag != "" && tag != "-" {
+		if name := strings.Split(tag, ",")[0]; name != "" {
+			return name
+		}
+	}
+	return sf.Name
+}
+
+func parseRules(tag string) ([]validationRule, error) {
+	rules := make([]validationRule, 0)
+	for tag != "" {
+		var part string
+		if strings.HasPrefix(tag, "regex=") { // Consumes the remainder
+			part, tag = tag, ""
+		} else if i := strings.Index(tag, ","); i >= 0 {
+			part, tag = tag[:i], tag[i+1:]
+		} else {
+			part, tag = tag, ""
+		}
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		rule := validationRule{name: part}
+		if i := strings.Index(part, "="); i >= 0 {
+			rule.name, rule.arg = part[:i], part[i+1:]
+		}
+		switch rule.name {
+		case "required":
+		case "min", "max", "len", "minlen", "maxlen":
+			n, err := strconv.ParseFloat(rule.arg, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s rule [ %s ]", rule.name, part)
+			}
+			rule.num = n
+		case "enum":
+			rule.enum = strings.Split(rule.arg, "|")
+		case "regex":
+			regex, err := regexp.Compile(rule.arg)
+			if err != nil {
+				return nil, fmt.Errorf("invalid regex rule [ %s ]: %s", part, err)
+			}
+			rule.regex = regex
+		default:
+			return nil, fmt.Errorf("unknown validation rule [ %s ]", part)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+func isEmpty(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.String, reflect.Slice, reflect.Map, reflect.Array:
+		return v.Len() == 0
+	case reflect.Ptr, reflect.Interface:
+		return v.IsNil()
+	}
+	return v.IsZero()
+}
+
+func (rule validationRule) check(v reflect.Value) (string, bool) {
+	if rule.name == "required" {
+		return "is required", !isEmpty(v)
+	}
+	switch v.Kind() {
+	case reflect.String, reflect.Slice, reflect.Map, reflect.Ptr, reflect.Interface:
+		if isEmpty(v) {
+			return "", true
+		}
+	}
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		v = v.Elem()
+	}
+	switch rule.name {
+	case "min", "max":
+		n, ok := number(v)
+		if !ok {
+			return "is not numeric", false
+		}
+		if rule.name == "min" {
+			return fmt.Sprintf("must be at least %s", rule.arg), n >= rule.num
+		}
+		return fmt.Sprintf("must be at most %s", rule.arg), n <= rule.num
+	case "len", "minlen", "maxlen":
+		switch v.Kind() {
+		case reflect.String, reflect.Slice, reflect.Map, reflect.Array:
+		default:
+			return "has no length", false
+		}
+		l := float64(v.Len())
+		if v.Kind() == reflect.String {
+			l = float64(len([]rune(v.String())))
+		}
+		switch rule.name {
+		case "len":
+			return fmt.Sprintf("must have length %s", rule.arg), l == rule.num
+		case "minlen":
+			return fmt.Sprintf("must have length of at least %s", rule.arg), l >= rule.num
+		}
+		return fmt.Sprintf("must have length of at most %s", rule.arg), l <= rule.num
+	case "enum":
+		s := fmt.Sprintf("%v", v) // Interface() panics on fields promoted from unexported embeds
+		for _, e := range rule.enum {
+			if s == e {
+				return "", true
+			}
+		}
+		return fmt.Sprintf("must be one of [ %s ]", strings.Join(rule.enum, ", ")), false
+	case "regex":
+		if v.Kind() != reflect.String {
+			return "is not a string", false
+		}
+		return fmt.Sprintf("must match [ %s ]", rule.arg), rule.regex.MatchString(v.String())
+	}
+	return "", true
+}
+
+func number(v reflect.Value) (float64, bool) {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), true
+	}
+	return 0, false
+}
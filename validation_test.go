@@ -0,0 +1,76 @@
+package cqrs_test
+
+import (
+	"github.com/xzeus/cqrs"
+	. "github.com/xzeus/cqrs/testing"
+	"testing"
+)
+
+type validatedAddress struct {
+	Zip string `json:"zip" validate:"required,len=5"`
+}
+
+type validatedCommand struct {
+	cqrs.JsonSerialized
+	Name    string            `json:"name" validate:"required,minlen=2,maxlen=8"`
+	Age     int               `json:"age" validate:"min=18,max=130"`
+	Role    string            `json:"role" validate:"enum=admin|user"`
+	Code    string            `json:"code" validate:"regex=^[a-z]{2,3}$"`
+	Address *validatedAddress `json:"address"`
+}
+
+func Test_Should_pass_valid_payload(t *testing.T) {
+	errs := cqrs.Validate(&validatedCommand{Name: "bob", Age: 30, Role: "user", Code: "ab", Address: &validatedAddress{Zip: "12345"}})
+	Equals(t, 0, len(errs), "Expected no errors but got [ %s ]", errs)
+}
+
+func Test_Should_skip_optional_empty_fields(t *testing.T) {
+	errs := cqrs.Validate(&validatedCommand{Name: "bob", Age: 30})
+	Equals(t, 0, len(errs), "Expected no errors but got [ %s ]", errs)
+}
+
+func Test_Should_report_field_errors(t *testing.T) {
+	errs := cqrs.Validate(&validatedCommand{Name: "b", Age: 12, Role: "root", Code: "a,b", Address: &validatedAddress{}})
+	expected := []struct{ field, rule string }{
+		{"name", "minlen"},
+		{"age", "min"},
+		{"role", "enum"},
+		{"code", "regex"},
+		{"address.zip", "required"},
+	}
+	Equals(t, len(expected), len(errs), "Expected errors [ %s ]", errs)
+	for i, e := range expected {
+		if i < len(errs) {
+			Equals(t, e.field, errs[i].Field, "")
+			Equals(t, e.rule, errs[i].Rule, "")
+		}
+	}
+}
+
+type malformedCommand struct {
+	Age int `json:"age" validate:"min=eighteen"`
+}
+
+type embeddedRules struct {
+	Role string `validate:"enum=admin|user"`
+}
+
+type embeddingCommand struct {
+	embeddedRules
+	Name string `json:"name" validate:"required"`
+}
+
+func Test_Should_reject_malformed_rules_when_compiled(t *testing.T) {
+	NotOk(t, cqrs.CompileValidation(&malformedCommand{}))
+	Ok(t, cqrs.CompileValidation(&validatedCommand{}))
+}
+
+func Test_Should_validate_fields_of_unexported_embedded_structs(t *testing.T) {
+	Ok(t, cqrs.CompileValidation(&embeddingCommand{}))
+	errs := cqrs.Validate(&embeddingCommand{embeddedRules: embeddedRules{Role: "root"}})
+	Equals(t, 2, len(errs), "Expected the role and name errors [ %s ]", errs)
+	Equals(t, "Role", errs[0].Field, "")
+	Equals(t, "name", errs[1].Field, "")
+	errs = cqrs.Validate(&embeddingCommand{embeddedRules: embeddedRules{Role: "admin"}, Name: "a"})
+	Equals(t, 0, len(errs), "Expected no errors [ %s ]", errs)
+}